@@ -0,0 +1,117 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sync"
+
+	"cmd/asm/internal/arch"
+	"cmd/asm/internal/lex"
+	"cmd/internal/obj"
+	"cmd/internal/objabi"
+	"cmd/internal/sys"
+)
+
+// Config holds the settings that the asm command takes from its
+// flags and the environment, for Assemble.
+type Config struct {
+	GOARCH   string   // target architecture, such as "amd64"
+	GOOS     string   // target operating system; if empty, $GOOS or the default
+	Defines  []string // predefined macros, as name or name=value (-D)
+	Includes []string // directories to search for #include files (-I)
+	TrimPath string   // prefix to remove from recorded source file paths (-trimpath)
+	Shared   bool     // generate code that can be linked into a shared library (-shared)
+	Dynlink  bool     // support references to Go symbols defined in other shared libraries (-dynlink)
+}
+
+// The backends in cmd/internal/obj build their instruction tables
+// on first use and keep some symbols in package variables, so each
+// architecture family's backend is used by one Assemble at a time.
+var (
+	backendMu    sync.Mutex
+	backendLocks = make(map[sys.ArchFamily]*sync.Mutex)
+)
+
+func backendLock(family sys.ArchFamily) *sync.Mutex {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	mu := backendLocks[family]
+	if mu == nil {
+		mu = new(sync.Mutex)
+		backendLocks[family] = mu
+	}
+	return mu
+}
+
+// Assemble assembles src, read as the file name, and returns the
+// object file that the asm command would write for it. It also
+// returns the diagnostics for any errors and warnings found;
+// if there are errors, the error is non-nil and there is no
+// object file.
+//
+// Unlike the asm command, Assemble takes its settings from cfg
+// rather than from flags, and it does not exit on errors.
+// It is safe to call concurrently. Settings that select among
+// variants of an architecture, such as GOARM and GOMIPS,
+// still come from the environment.
+func Assemble(cfg Config, name string, src []byte) ([]byte, []Diagnostic, error) {
+	architecture := arch.Set(cfg.GOARCH)
+	if architecture == nil {
+		return nil, nil, fmt.Errorf("asm: unrecognized architecture %s", cfg.GOARCH)
+	}
+	goos := cfg.GOOS
+	if goos == "" {
+		goos = objabi.GOOS
+	}
+	ctxt := obj.Linknew(architecture.LinkArch)
+	if err := ctxt.Headtype.Set(goos); err != nil {
+		return nil, nil, fmt.Errorf("asm: unrecognized operating system %s", goos)
+	}
+	ctxt.Framepointer_enabled = objabi.Framepointer_enabled(goos, architecture.Name)
+	ctxt.Flag_dynlink = cfg.Dynlink
+	ctxt.Flag_shared = cfg.Shared || cfg.Dynlink
+	var diags []Diagnostic
+	failed := false
+	ctxt.DiagFunc = func(format string, args ...interface{}) {
+		failed = true
+		diags = append(diags, Diagnostic{File: name, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	input, err := lex.NewInputFromSource(name, src, cfg.Includes, cfg.Defines, cfg.TrimPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("asm: parsing define: %v", err)
+	}
+	p := newParser(ctxt, architecture, input)
+	p.allErrors = true
+	pList := new(obj.Plist)
+	var ok bool
+	pList.Firstpc, ok = p.Parse()
+	diags = append(p.diags, diags...)
+	if !ok || failed {
+		return nil, diags, fmt.Errorf("assembly of %s failed", name)
+	}
+
+	mu := backendLock(architecture.Family)
+	mu.Lock()
+	defer mu.Unlock()
+	architecture.Init(ctxt)
+	obj.Flushplist(ctxt, pList, nil, "")
+	if failed {
+		return nil, diags, fmt.Errorf("assembly of %s failed", name)
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	fmt.Fprintf(w, "go object %s %s %s\n", goos, cfg.GOARCH, objabi.Version)
+	fmt.Fprintf(w, "!\n")
+	obj.WriteObjFile(ctxt, w)
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), diags, nil
+}
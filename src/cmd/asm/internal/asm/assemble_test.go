@@ -0,0 +1,211 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asmtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "frame.h"), []byte("#define FRAME $0\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	src := []byte(`#include "frame.h"
+TEXT ·f(SB), FLAGS, FRAME
+	MOVQ	AX, BX
+	RET
+`)
+	cfg := Config{
+		GOARCH:   "amd64",
+		GOOS:     "linux",
+		Defines:  []string{"FLAGS=4"},
+		Includes: []string{dir},
+	}
+	obj, diags, err := Assemble(cfg, "f.s", src)
+	if err != nil {
+		t.Fatalf("Assemble: %v %v", err, diags)
+	}
+	if !bytes.HasPrefix(obj, []byte("go object linux amd64 ")) || !bytes.Contains(obj, []byte("\n!\n\x00go112ld")) {
+		t.Errorf("Assemble returned %q..., not an object file", obj[:40])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		src   string
+		err   string
+		diags []Diagnostic
+	}{
+		{
+			name: "unknown arch",
+			cfg:  Config{GOARCH: "vax"},
+			err:  "unrecognized architecture vax",
+		},
+		{
+			name: "unknown os",
+			cfg:  Config{GOARCH: "amd64", GOOS: "tops20"},
+			err:  "unrecognized operating system tops20",
+		},
+		{
+			name: "bad define",
+			cfg:  Config{GOARCH: "amd64", Defines: []string{"1X=2"}},
+			err:  `"1X" is not a valid identifier name`,
+		},
+		{
+			name: "parse errors",
+			cfg:  Config{GOARCH: "amd64"},
+			src:  "TEXT ·f(SB), 0, $0\n\tFOO\tAX\n\tMOVQ\tAX, BX(\n\tRET\n",
+			err:  "assembly of x.s failed",
+			diags: []Diagnostic{
+				{File: "x.s", Line: 2, Col: 2, EndCol: 8, Msg: `unrecognized instruction "FOO"`},
+				{File: "x.s", Line: 3, Col: 11, EndCol: 14, Msg: "expected end of operand, found ("},
+			},
+		},
+		{
+			name: "macro expansion",
+			cfg:  Config{GOARCH: "amd64"},
+			src:  "#define BAD MOVQ AX, BX(\nTEXT ·f(SB), 0, $0\n\tBAD\n",
+			err:  "assembly of x.s failed",
+			diags: []Diagnostic{
+				{
+					File: "x.s", Line: 3, Col: 2, EndCol: 5, Msg: "expected end of operand, found (",
+					Related: []Related{{File: "x.s", Line: 1, Col: 9, EndCol: 12, Msg: "in expansion of macro BAD"}},
+				},
+			},
+		},
+		{
+			name: "#error",
+			cfg:  Config{GOARCH: "amd64"},
+			src:  "#ifndef GOARCH_vax\n#error no vax\n#endif\n",
+			err:  "assembly of x.s failed",
+			diags: []Diagnostic{
+				{File: "x.s", Line: 2, Col: 2, EndCol: 14, Msg: "#error no vax"},
+			},
+		},
+		{
+			name: "lexer error",
+			cfg:  Config{GOARCH: "arm64"},
+			src:  "TEXT ·f(SB), 0, $0\n#include \"missing.h\"\n",
+			err:  "assembly of x.s failed",
+			diags: []Diagnostic{
+				{File: "x.s", Line: 2, Col: 10, EndCol: 21, Msg: "#include: open missing.h: no such file or directory"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		obj, diags, err := Assemble(tt.cfg, "x.s", []byte(tt.src))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+		if obj != nil {
+			t.Errorf("%s: returned an object file", tt.name)
+		}
+		if !reflect.DeepEqual(diags, tt.diags) {
+			t.Errorf("%s: diagnostics:\n%v\nwant:\n%v", tt.name, diags, tt.diags)
+		}
+	}
+}
+
+func TestAssembleWarning(t *testing.T) {
+	src := []byte("#if 1\n#warning slow\n#endif\nTEXT ·f(SB), 0, $0\n\tRET\n")
+	obj, diags, err := Assemble(Config{GOARCH: "amd64"}, "x.s", src)
+	if err != nil || obj == nil {
+		t.Fatalf("Assemble: %v %v", err, diags)
+	}
+	want := []Diagnostic{{File: "x.s", Line: 2, Col: 2, EndCol: 14, Severity: SeverityWarning, Msg: "#warning slow"}}
+	if !reflect.DeepEqual(diags, want) {
+		t.Errorf("diagnostics:\n%v\nwant:\n%v", diags, want)
+	}
+}
+
+func TestAssembleIncludeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asmtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.h"), []byte("\tFOO\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	src := []byte("TEXT ·f(SB), 0, $0\n#include \"bad.h\"\n\tRET\n")
+	_, diags, err := Assemble(Config{GOARCH: "amd64", Includes: []string{dir}}, "x.s", src)
+	if err == nil {
+		t.Fatal("Assemble succeeded")
+	}
+	want := []Diagnostic{
+		{
+			File: "bad.h", Line: 1, Col: 2, EndCol: 5, Msg: `unrecognized instruction "FOO"`,
+			Related: []Related{{File: "x.s", Line: 2, Col: 10, EndCol: 17, Msg: "included from here"}},
+		},
+	}
+	if !reflect.DeepEqual(diags, want) {
+		t.Errorf("diagnostics:\n%v\nwant:\n%v", diags, want)
+	}
+}
+
+// TestAssembleConcurrent checks that concurrent calls of Assemble,
+// for the same and for different architectures, give the same
+// object files as sequential ones.
+func TestAssembleConcurrent(t *testing.T) {
+	files := map[string]string{
+		"386":     "386",
+		"amd64":   "amd64",
+		"arm":     "arm",
+		"arm64":   "arm64",
+		"mips64":  "mips64",
+		"ppc64le": "ppc64",
+		"s390x":   "s390x",
+		"wasm":    "wasm",
+	}
+	srcs := make(map[string][]byte)
+	want := make(map[string][]byte)
+	for goarch, file := range files {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", file+".s"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, diags, err := Assemble(Config{GOARCH: goarch, GOOS: "linux"}, file+".s", src)
+		if err != nil {
+			t.Fatalf("%s: %v %v", goarch, err, diags)
+		}
+		srcs[goarch] = src
+		want[goarch] = obj
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for goarch, file := range files {
+			wg.Add(1)
+			go func(goarch, file string) {
+				defer wg.Done()
+				obj, diags, err := Assemble(Config{GOARCH: goarch, GOOS: "linux"}, file+".s", srcs[goarch])
+				if err != nil {
+					t.Errorf("%s: %v %v", goarch, err, diags)
+					return
+				}
+				if !bytes.Equal(obj, want[goarch]) {
+					t.Errorf("%s: concurrent Assemble gave a different object file", goarch)
+				}
+			}(goarch, file)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"fmt"
+	"io"
+
+	"cmd/asm/internal/lex"
+)
+
+// A Severity says how serious a Diagnostic is.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+var severityNames = []string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityNote:    "note",
+}
+
+func (s Severity) String() string {
+	if 0 <= int(s) && int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// MarshalText encodes the severity by name, as in JSON output.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// A Diagnostic is a problem found while assembling.
+// Columns are counted in characters from 1; EndCol is the column
+// after the last character of the problem, on the same line.
+// In JSON, unknown positions are omitted.
+type Diagnostic struct {
+	File     string `json:",omitempty"` // source file name, or "" if not known
+	Line     int    `json:",omitempty"` // line number, or 0 if not known
+	Col      int    `json:",omitempty"` // starting column, or 0 if not known
+	EndCol   int    `json:",omitempty"` // ending column, or 0 if not known
+	Severity Severity
+	Msg      string
+	Related  []Related `json:",omitempty"` // other positions involved, innermost first
+}
+
+// A Related is a position that explains a Diagnostic, such as the
+// definition of a macro whose expansion contains the problem or the
+// #include directive of the file that contains it.
+type Related struct {
+	File   string `json:",omitempty"`
+	Line   int    `json:",omitempty"`
+	Col    int    `json:",omitempty"`
+	EndCol int    `json:",omitempty"`
+	Msg    string
+}
+
+func (d Diagnostic) String() string {
+	msg := d.Msg
+	if d.Severity != SeverityError {
+		msg = d.Severity.String() + ": " + msg
+	}
+	return position(d.File, d.Line, d.Col) + msg
+}
+
+func (r Related) String() string {
+	return position(r.File, r.Line, r.Col) + r.Msg
+}
+
+// position formats a position as a prefix for a message.
+func position(file string, line, col int) string {
+	switch {
+	case file == "":
+		return ""
+	case line == 0:
+		return file + ": "
+	case col == 0:
+		return fmt.Sprintf("%s:%d: ", file, line)
+	}
+	return fmt.Sprintf("%s:%d:%d: ", file, line, col)
+}
+
+// printDiagnostic prints d to w, followed by its related positions
+// indented on the lines after it.
+func printDiagnostic(w io.Writer, d Diagnostic) {
+	fmt.Fprintln(w, d)
+	for _, r := range d.Related {
+		fmt.Fprintf(w, "\t%s\n", r)
+	}
+}
+
+// setOrigin sets the columns and the related positions of d from o,
+// the origin of the source text that d is about. The columns are set
+// only if o is on the line of d.
+func (d *Diagnostic) setOrigin(o *lex.Origin) {
+	if o == nil {
+		return
+	}
+	if o.File == d.File && o.Line == d.Line {
+		d.Col, d.EndCol = o.Col, o.EndCol
+	}
+	for _, f := range o.Context {
+		d.Related = append(d.Related, Related{f.File, f.Line, f.Col, f.EndCol, f.Msg})
+	}
+}
+
+// span returns the origin of the text from the start of first
+// to the end of last, with the context of first.
+// Either may be nil if not known.
+func span(first, last *lex.Origin) *lex.Origin {
+	if first == nil {
+		return last
+	}
+	o := *first
+	if last != nil && last.File == o.File && last.Line == o.Line && last.EndCol > o.Col {
+		o.EndCol = last.EndCol
+	}
+	return &o
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testDiag = Diagnostic{
+	File:     "x.s",
+	Line:     3,
+	Col:      2,
+	EndCol:   5,
+	Severity: SeverityWarning,
+	Msg:      "odd",
+	Related:  []Related{{File: "x.s", Line: 1, Col: 9, EndCol: 12, Msg: "in expansion of macro BAD"}},
+}
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{Msg: "bad"}, "bad"},
+		{Diagnostic{File: "x.s", Msg: "bad"}, "x.s: bad"},
+		{Diagnostic{File: "x.s", Line: 3, Msg: "bad"}, "x.s:3: bad"},
+		{Diagnostic{File: "x.s", Line: 3, Col: 2, Msg: "bad"}, "x.s:3:2: bad"},
+		{Diagnostic{File: "x.s", Line: 3, Severity: SeverityNote, Msg: "see"}, "x.s:3: note: see"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	printDiagnostic(&buf, testDiag)
+	want := "x.s:3:2: warning: odd\n\tx.s:1:9: in expansion of macro BAD\n"
+	if buf.String() != want {
+		t.Errorf("printDiagnostic printed %q, want %q", buf.String(), want)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	data, err := json.Marshal([]Diagnostic{{Msg: "bad"}, testDiag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"Severity":"error","Msg":"bad"},` +
+		`{"File":"x.s","Line":3,"Col":2,"EndCol":5,"Severity":"warning","Msg":"odd",` +
+		`"Related":[{"File":"x.s","Line":1,"Col":9,"EndCol":12,"Msg":"in expansion of macro BAD"}]}]`
+	if string(data) != want {
+		t.Errorf("JSON:\n%s\nwant:\n%s", data, want)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cmd/asm/internal/arch"
+	"cmd/asm/internal/lex"
+	"cmd/internal/obj"
+	"cmd/internal/objabi"
+)
+
+// setArch configures the assembler for goarch and returns
+// the architecture and a new link context for it.
+func setArch(goarch string) (*arch.Arch, *obj.Link) {
+	objabi.GOOS = "linux" // obj can handle this OS for all architectures.
+	objabi.GOARCH = goarch
+	architecture := arch.Set(goarch)
+	if architecture == nil {
+		panic("asm: unrecognized architecture " + goarch)
+	}
+	return architecture, obj.Linknew(architecture.LinkArch)
+}
+
+// encodingRE matches an instruction line ending in a comment
+// that gives the instruction's encoding in hex.
+var encodingRE = regexp.MustCompile(`^\s*[^/\s].*//\s*([0-9a-f]+)\s*$`)
+
+// testEndToEnd assembles testdata/file.s for goarch and checks the
+// machine code of every line that ends in an encoding comment, like
+//
+//	MOVQ	AX, BX	// 4889c3
+//
+// If swap is set, the comments give big-endian 32-bit words and
+// the code is compared with them after reversing each word.
+func testEndToEnd(t *testing.T, goarch, file string, swap bool) {
+	input := filepath.Join("testdata", file+".s")
+	data, err := ioutil.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[int]string) // line number -> encoding
+	var lines []int
+	for i, line := range strings.Split(string(data), "\n") {
+		m := encodingRE.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		enc := m[1]
+		if swap {
+			enc = swapWords(t, enc)
+		}
+		want[i+1] = enc
+		lines = append(lines, i+1)
+	}
+
+	architecture, ctxt := setArch(goarch)
+	architecture.Init(ctxt)
+	ctxt.DiagFunc = func(format string, args ...interface{}) {
+		t.Errorf(format, args...)
+	}
+	parser := NewParser(ctxt, architecture, lex.NewLexer(input))
+	var errBuf bytes.Buffer
+	parser.errorWriter = &errBuf
+	pList := new(obj.Plist)
+	var ok bool
+	pList.Firstpc, ok = parser.Parse()
+	if !ok {
+		t.Fatalf("assembly of %s failed:\n%s", input, errBuf.String())
+	}
+	obj.Flushplist(ctxt, pList, nil, "")
+
+	if goarch == "wasm" {
+		// The wasm backend does not record the offset of each
+		// instruction, so look for the encodings in order.
+		var code []byte
+		for _, s := range ctxt.Text {
+			code = append(code, s.P...)
+		}
+		text := hex.EncodeToString(code)
+		for _, line := range lines {
+			i := strings.Index(text, want[line])
+			if i < 0 || i%2 != 0 {
+				t.Errorf("%s:%d: encoding %s not found in %s", input, line, want[line], text)
+				continue
+			}
+			text = text[i+len(want[line]):]
+		}
+		return
+	}
+
+	got := make(map[int]string)
+	padded := make(map[int]bool) // the code may end in alignment padding
+	for _, s := range ctxt.Text {
+		for p := s.Func.Text; p != nil; p = p.Link {
+			end := int64(len(s.P))
+			if p.Link != nil {
+				end = p.Link.Pc
+			}
+			if p.As == obj.ATEXT || end <= p.Pc {
+				continue
+			}
+			line := int(ctxt.PosTable.Pos(p.Pos).Line())
+			got[line] += hex.EncodeToString(s.P[p.Pc:end])
+			padded[line] = p.Link == nil
+		}
+	}
+	for _, line := range lines {
+		enc := got[line]
+		if padded[line] && strings.HasPrefix(enc, want[line]) && strings.Trim(enc[len(want[line]):], "0") == "" {
+			enc = want[line]
+		}
+		if enc != want[line] {
+			t.Errorf("%s:%d: encoding %s, want %s", input, line, got[line], want[line])
+		}
+	}
+}
+
+// swapWords reverses the bytes of each 32-bit word in the hex string enc.
+func swapWords(t *testing.T, enc string) string {
+	if len(enc)%8 != 0 {
+		t.Fatalf("encoding %s is not a sequence of 32-bit words", enc)
+	}
+	var b strings.Builder
+	for i := 0; i < len(enc); i += 8 {
+		w := enc[i : i+8]
+		b.WriteString(w[6:8] + w[4:6] + w[2:4] + w[0:2])
+	}
+	return b.String()
+}
+
+func Test386EndToEnd(t *testing.T) {
+	defer func(old string) { objabi.GO386 = old }(objabi.GO386)
+	for _, go386 := range []string{"387", "sse2"} {
+		t.Logf("GO386=%s", go386)
+		objabi.GO386 = go386
+		testEndToEnd(t, "386", "386", false)
+	}
+}
+
+func TestAMD64EndToEnd(t *testing.T) {
+	testEndToEnd(t, "amd64", "amd64", false)
+}
+
+func TestAMD64p32EndToEnd(t *testing.T) {
+	testEndToEnd(t, "amd64p32", "amd64", false)
+}
+
+func TestARMEndToEnd(t *testing.T) {
+	defer func(old int) { objabi.GOARM = old }(objabi.GOARM)
+	for _, goarm := range []int{5, 6, 7} {
+		t.Logf("GOARM=%d", goarm)
+		objabi.GOARM = goarm
+		testEndToEnd(t, "arm", "arm", false)
+	}
+}
+
+func TestARM64EndToEnd(t *testing.T) {
+	testEndToEnd(t, "arm64", "arm64", false)
+}
+
+func TestMIPSEndToEnd(t *testing.T) {
+	testEndToEnd(t, "mips", "mips", false)
+}
+
+func TestMIPSLEEndToEnd(t *testing.T) {
+	testEndToEnd(t, "mipsle", "mips", true)
+}
+
+func TestMIPS64EndToEnd(t *testing.T) {
+	testEndToEnd(t, "mips64", "mips64", false)
+}
+
+func TestMIPS64LEEndToEnd(t *testing.T) {
+	testEndToEnd(t, "mips64le", "mips64", true)
+}
+
+func TestPPC64EndToEnd(t *testing.T) {
+	testEndToEnd(t, "ppc64", "ppc64", false)
+}
+
+func TestPPC64LEEndToEnd(t *testing.T) {
+	testEndToEnd(t, "ppc64le", "ppc64", true)
+}
+
+func TestS390XEndToEnd(t *testing.T) {
+	testEndToEnd(t, "s390x", "s390x", false)
+}
+
+func TestWasmEndToEnd(t *testing.T) {
+	testEndToEnd(t, "wasm", "wasm", false)
+}
@@ -42,7 +42,12 @@ type Parser struct {
 	lastProg      *obj.Prog
 	dataAddr      map[string]int64 // Most recent address for DATA for this symbol.
 	isJump        bool             // Instruction being assembled is a jump.
-	errorWriter   io.Writer
+	errorWriter   io.Writer        // Where errors are printed, if non-nil.
+	allErrors     bool             // Report all errors, not just the first 10 (-e).
+	debug         bool             // Print instructions as they are parsed (-debug).
+	diags         []Diagnostic     // Errors and warnings reported so far.
+	lineStart     *lex.Origin      // Origin of the first token of the line.
+	lineEnd       *lex.Origin      // Origin of the last token of the line read so far.
 }
 
 type Patch struct {
@@ -50,15 +55,36 @@ type Patch struct {
 	label string
 }
 
+// NewParser returns a parser that reads from lexer, prints errors
+// to standard error and follows the -e and -debug flags.
+// With -json, it reports all errors but does not print them;
+// the caller prints its Diagnostics.
 func NewParser(ctxt *obj.Link, ar *arch.Arch, lexer lex.TokenReader) *Parser {
-	return &Parser{
-		ctxt:        ctxt,
-		arch:        ar,
-		lex:         lexer,
-		labels:      make(map[string]*obj.Prog),
-		dataAddr:    make(map[string]int64),
-		errorWriter: os.Stderr,
+	p := newParser(ctxt, ar, lexer)
+	p.errorWriter = os.Stderr
+	p.allErrors = *flags.AllErrors
+	if *flags.JSON {
+		p.errorWriter = nil
+		p.allErrors = true
+	}
+	p.debug = *flags.Debug
+	return p
+}
+
+// newParser returns a parser that reads from lexer
+// and only records the errors it finds.
+func newParser(ctxt *obj.Link, ar *arch.Arch, lexer lex.TokenReader) *Parser {
+	p := &Parser{
+		ctxt:     ctxt,
+		arch:     ar,
+		lex:      lexer,
+		labels:   make(map[string]*obj.Prog),
+		dataAddr: make(map[string]int64),
+	}
+	if in, ok := lexer.(*lex.Input); ok {
+		in.WarnFunc = p.inputWarning
 	}
+	return p
 }
 
 // panicOnError is enabled when testing to abort execution on the first error
@@ -74,28 +100,45 @@ func (p *Parser) errorf(format string, args ...interface{}) {
 		return
 	}
 	p.errorLine = p.lineNum
+	d := Diagnostic{Msg: fmt.Sprintf(format, args...)}
 	if p.lex != nil {
 		// Put file and line information on head of message.
-		format = "%s:%d: " + format + "\n"
-		args = append([]interface{}{p.lex.File(), p.lineNum}, args...)
+		d.File, d.Line = p.lex.File(), p.lineNum
+		d.setOrigin(p.errorOrigin())
+	}
+	p.diags = append(p.diags, d)
+	if p.errorWriter != nil {
+		printDiagnostic(p.errorWriter, d)
 	}
-	fmt.Fprintf(p.errorWriter, format, args...)
 	p.errorCount++
-	if p.errorCount > 10 && !*flags.AllErrors {
+	if p.errorCount > 10 && !p.allErrors {
 		log.Fatal("too many errors")
 	}
 }
 
+// errorOrigin returns the origin of the text that an error is about:
+// the operand being parsed, if any, or else the line.
+func (p *Parser) errorOrigin() *lex.Origin {
+	if len(p.input) > 0 {
+		return span(p.input[0].Origin(), p.input[len(p.input)-1].Origin())
+	}
+	return span(p.lineStart, p.lineEnd)
+}
+
+// Diagnostics returns the errors and warnings reported so far.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diags
+}
+
 func (p *Parser) pos() src.XPos {
 	return p.ctxt.PosTable.XPos(src.MakePos(p.lex.Base(), uint(p.lineNum), 0))
 }
 
-func (p *Parser) Parse() (*obj.Prog, bool) {
+func (p *Parser) Parse() (prog *obj.Prog, ok bool) {
+	defer p.recoverInputError(&ok)
 	scratch := make([][]lex.Token, 0, 3)
 	for {
 		word, cond, operands, ok := p.line(scratch)
-
-		fmt.Printf("parse word[%v],cond[%v],operands[%v],ok[%v]\n", word, cond, operands, ok)
 		if !ok {
 			break
 		}
@@ -120,7 +163,8 @@ func (p *Parser) Parse() (*obj.Prog, bool) {
 
 // ParseSymABIs parses p's assembly code to find text symbol
 // definitions and references and writes a symabis file to w.
-func (p *Parser) ParseSymABIs(w io.Writer) bool {
+func (p *Parser) ParseSymABIs(w io.Writer) (ok bool) {
+	defer p.recoverInputError(&ok)
 	operands := make([][]lex.Token, 0, 3)
 	for {
 		word, _, operands1, ok := p.line(operands)
@@ -134,6 +178,39 @@ func (p *Parser) ParseSymABIs(w io.Writer) bool {
 	return p.errorCount == 0
 }
 
+// recoverInputError recovers from an error in the input that the
+// lexer cannot recover from, if it reported one by panicking with a
+// *lex.Error, and records it. It then sets *ok to false.
+func (p *Parser) recoverInputError(ok *bool) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	err, isLex := e.(*lex.Error)
+	if !isLex {
+		panic(e)
+	}
+	p.inputDiagnostic(err, SeverityError)
+	p.errorCount++
+	*ok = false
+}
+
+// inputWarning records a warning from the input, such as a #warning directive.
+func (p *Parser) inputWarning(w *lex.Error) {
+	p.inputDiagnostic(w, SeverityWarning)
+}
+
+// inputDiagnostic records, and prints if p prints errors,
+// a diagnostic of the given severity for a problem in the input.
+func (p *Parser) inputDiagnostic(e *lex.Error, severity Severity) {
+	d := Diagnostic{File: e.File, Line: e.Line, Severity: severity, Msg: e.Msg}
+	d.setOrigin(&e.Origin)
+	p.diags = append(p.diags, d)
+	if p.errorWriter != nil {
+		printDiagnostic(p.errorWriter, d)
+	}
+}
+
 // line consumes a single assembly line from p.lex of the form
 //
 //   {label:} WORD[.cond] [ arg {, arg} ] (';' | '\n')
@@ -154,8 +231,6 @@ next:
 		// are labeled with this line. Otherwise we complain after we've absorbed
 		// the terminating newline and the line numbers are off by one in errors.
 		p.lineNum = p.lex.Line()
-		tt := p.lex.Text()
-		fmt.Printf("get a tok:[%+v],[%T],[%v],[%v]\n\n", tt, tt, p.lineNum, tok)
 		switch tok {
 		case '\n', ';':
 			continue
@@ -164,9 +239,8 @@ next:
 		}
 		break
 	}
-	tt := p.lex.Text()
-	fmt.Printf("get a tok:[%+v],[%T]\n", tt, tt)
-
+	p.start(nil)
+	p.lineStart, p.lineEnd = p.lex.Origin(), nil
 	// First item must be an identifier.
 	if tok != scanner.Ident {
 		p.errorf("expected identifier, found %q", p.lex.Text())
@@ -228,7 +302,8 @@ next:
 			if tok == ')' || tok == ']' {
 				nesting--
 			}
-			items = append(items, lex.Make(tok, p.lex.Text()))
+			p.lineEnd = p.lex.Origin()
+			items = append(items, lex.Make(tok, p.lex.Text()).WithOrigin(p.lineEnd))
 		}
 		if len(items) > 0 {
 			operands = append(operands, items)
@@ -255,6 +330,7 @@ func (p *Parser) instruction(op obj.As, word, cond string, operands [][]lex.Toke
 		}
 		p.addr = append(p.addr, addr)
 	}
+	p.start(nil) // Further errors are about the whole instruction.
 	if p.isJump {
 		p.asmJump(op, cond, p.addr)
 		return
@@ -300,6 +376,7 @@ func (p *Parser) symDefRef(w io.Writer, word string, operands [][]lex.Token) {
 		return
 	case "GLOBL", "PCDATA":
 		// No text definitions or symbol references.
+		return
 	case "DATA", "FUNCDATA":
 		// For DATA, operands[0] is defined symbol.
 		// For FUNCDATA, operands[0] is an immediate constant.
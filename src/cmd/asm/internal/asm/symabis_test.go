@@ -0,0 +1,47 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"cmd/asm/internal/lex"
+)
+
+// TestSymABIs runs ParseSymABIs over the inputs in testdata/symabis,
+// writing to one buffer as asm -gensymabis does for several files,
+// and compares the result with the golden .symabis file.
+func TestSymABIs(t *testing.T) {
+	tests := []struct {
+		goarch string
+		golden string
+		files  []string
+	}{
+		{"amd64", "amd64.symabis", []string{"amd64b.s", "amd64a.s"}},
+		{"arm64", "arm64.symabis", []string{"arm64.s"}},
+	}
+	for _, tt := range tests {
+		architecture, ctxt := setArch(tt.goarch)
+		var buf, errBuf bytes.Buffer
+		for _, file := range tt.files {
+			input := filepath.Join("testdata", "symabis", file)
+			parser := NewParser(ctxt, architecture, lex.NewLexer(input))
+			parser.errorWriter = &errBuf
+			if !parser.ParseSymABIs(&buf) {
+				t.Fatalf("ParseSymABIs(%s) failed:\n%s", input, errBuf.String())
+			}
+		}
+		want, err := ioutil.ReadFile(filepath.Join("testdata", "symabis", tt.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("%s: symabis for %v:\n%s\nwant:\n%s", tt.goarch, tt.files, got, want)
+		}
+	}
+}
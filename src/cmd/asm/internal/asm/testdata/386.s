@@ -0,0 +1,21 @@
+// This input is assembled by Test386EndToEnd, which checks the
+// encoding of each instruction that has a comment giving its bytes in hex.
+
+TEXT	foo(SB), 7, $0
+	MOVL	AX, BX			// 89c3
+	MOVL	$0x1234, CX		// b934120000
+	ADDL	$8, SP			// 83c408
+	MOVL	16(SP), AX		// 8b442410
+	LEAL	8(AX)(BX*4), DX		// 8d549808
+	XORL	AX, AX			// 31c0
+	CMPL	DX, CX			// 39ca
+	PUSHL	BP			// 55
+	POPL	BP			// 5d
+	IMULL	CX, AX			// 0fafc1
+	SHLL	$3, AX			// c1e003
+	MOVUPS	(SI), X0		// 0f1006
+	PXOR	X1, X1			// 660fefc9
+	BSWAPL	AX			// 0fc8
+	INCL	AX			// 40
+	CPUID				// 0fa2
+	RET				// c3
@@ -0,0 +1,23 @@
+// This input is assembled by TestAMD64EndToEnd and TestAMD64p32EndToEnd,
+// which check the encoding of each instruction that has a comment
+// giving its bytes in hex.
+
+TEXT	foo(SB), 7, $0
+	MOVQ	AX, BX			// 4889c3
+	MOVL	$0x1234, CX		// b934120000
+	ADDQ	$8, SP			// 4883c408
+	MOVQ	16(SP), AX		// 488b442410
+	LEAQ	8(AX)(BX*4), DX		// 488d549808
+	XORL	AX, AX			// 31c0
+	CMPQ	DX, CX			// 4839ca
+	PUSHQ	BP			// 55
+	POPQ	BP			// 5d
+	IMULQ	CX, AX			// 480fafc1
+	SHLQ	$3, AX			// 48c1e003
+	MOVUPS	(SI), X0		// 0f1006
+	PXOR	X1, X1			// 660fefc9
+	VPADDD	Y2, Y1, Y0		// c5f5fec2
+	POPCNTQ	CX, AX			// f3480fb8c1
+	BSWAPL	AX			// 0fc8
+	CPUID				// 0fa2
+	RET				// c3
@@ -0,0 +1,20 @@
+// This input is assembled by TestARMEndToEnd, which checks the
+// encoding of each instruction that has a comment giving its bytes in hex.
+
+TEXT	foo(SB), 7, $0
+	MOVW	R2, R1			// 0210a0e1
+	ADD	R2, R1, R0		// 020081e0
+	SUB	$1, R3			// 013043e2
+	MOVW	4(R13), R0		// 04009de5
+	MOVW	R1, 8(R0)		// 081080e5
+	MOVW	R1<<3, R0		// 8101a0e1
+	CMP	R2, R1			// 020051e1
+	MOVW.EQ	R1, R0			// 0100a001
+	AND	$255, R0		// ff0000e2
+	ORR	R6, R5, R4		// 064085e1
+	EOR	R4, R4			// 044024e0
+	MUL	R2, R1, R0		// 910200e0
+	CLZ	R1, R0			// 110f6fe1
+	REV	R1, R0			// 310fbfe6
+	DMB	MB_ISH			// 5bf07ff5
+	RET
@@ -0,0 +1,22 @@
+// This input is assembled by TestARM64EndToEnd, which checks the
+// encoding of each instruction that has a comment giving its bytes in hex.
+
+TEXT	foo(SB), 7, $-8
+	MOVD	R2, R1			// e10302aa
+	ADD	R2, R1, R0		// 2000028b
+	SUB	$1, R3			// 630400d1
+	MOVD	8(RSP), R0		// e00740f9
+	MOVW	R1, 4(R0)		// 010400b9
+	LSL	$3, R1, R0		// 20f07dd3
+	CMP	R2, R1			// 3f0002eb
+	CSEL	EQ, R1, R2, R0		// 2000829a
+	AND	$0xff, R0		// 001c4092
+	ORR	R6, R5, R4		// a40006aa
+	MUL	R2, R1, R0		// 207c029b
+	CLZ	R1, R0			// 2010c0da
+	REV	R1, R0			// 200cc0da
+	LDAXR	(R1), R0		// 20fc5fc8
+	STLXR	R3, (R4), R2		// 83fc02c8
+	FADDD	F2, F1, F0		// 2028621e
+	DMB	$0xb			// bf3b03d5
+	RET				// c0035fd6
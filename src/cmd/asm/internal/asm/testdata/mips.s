@@ -0,0 +1,19 @@
+// This input is assembled by TestMIPSEndToEnd and TestMIPSLEEndToEnd,
+// which check the encoding of each instruction that has a comment
+// giving its bytes in hex. The bytes are those of big-endian mips;
+// the test swaps them for mipsle.
+
+TEXT	foo(SB), 7, $-4
+	MOVW	R2, R3			// 00401825
+	ADDU	R4, R3, R2		// 00641021
+	ADDU	$-1, R3			// 2463ffff
+	MOVW	8(R29), R2		// 8fa20008
+	MOVW	R3, 4(R2)		// ac430004
+	SLL	$3, R3, R2		// 000310c0
+	AND	R6, R5, R4		// 00a62024
+	OR	R6, R5, R4		// 00a62025
+	XOR	R4, R4			// 00842026
+	AND	$255, R2		// 304200ff
+	SYNC				// 0000000f
+	NOR	R6, R5, R4		// 00a62027
+	RET
@@ -0,0 +1,16 @@
+// This input is assembled by TestMIPS64EndToEnd and TestMIPS64LEEndToEnd,
+// which check the encoding of each instruction that has a comment
+// giving its bytes in hex. The bytes are those of big-endian mips64;
+// the test swaps them for mips64le.
+
+TEXT	foo(SB), 7, $-8
+	MOVV	R2, R3			// 00401825
+	ADDVU	R4, R3, R2		// 0064102d
+	ADDVU	$-1, R3			// 6463ffff
+	MOVV	8(R29), R2		// dfa20008
+	MOVV	R3, 16(R2)		// fc430010
+	SLLV	$3, R3, R2		// 000310f8
+	AND	R6, R5, R4		// 00a62024
+	OR	R6, R5, R4		// 00a62025
+	SYNC				// 0000000f
+	RET
@@ -0,0 +1,22 @@
+// This input is assembled by TestPPC64EndToEnd and TestPPC64LEEndToEnd,
+// which check the encoding of each instruction that has a comment
+// giving its bytes in hex. The bytes are those of big-endian ppc64;
+// the test swaps them for ppc64le.
+
+TEXT	foo(SB), 7, $-8
+	MOVD	R4, R3			// 7c832378
+	ADD	R5, R4, R3		// 7c642a14
+	ADD	$-1, R3			// 3863ffff
+	MOVD	8(R1), R3		// e8610008
+	MOVW	R4, 16(R3)		// 90830010
+	SLD	$3, R4, R3		// 78831f24
+	CMP	R4, R5			// 7c242800
+	AND	R5, R4, R3		// 7c832838
+	OR	R5, R4, R3		// 7c832b78
+	XOR	R3, R3			// 7c631a78
+	MULLD	R5, R4, R3		// 7c6429d2
+	CNTLZD	R4, R3			// 7c830074
+	LWSYNC				// 7c2004ac
+	SYNC				// 7c0004ac
+	ISYNC				// 4c00012c
+	RET				// 4e800020
@@ -0,0 +1,17 @@
+// This input is assembled by TestS390XEndToEnd, which checks the
+// encoding of each instruction that has a comment giving its bytes in hex.
+
+TEXT	foo(SB), 7, $-8
+	MOVD	R4, R3			// b9040034
+	ADD	R4, R3			// b9e84033
+	ADD	$-1, R3			// a73bffff
+	MOVD	8(R15), R3		// e330f0080004
+	MOVD	R4, 16(R3)		// e34030100024
+	SLD	$3, R4, R3		// eb340003000d
+	CMP	R4, R5			// b9200045
+	AND	R4, R3			// b9800034
+	OR	R4, R3			// b9810034
+	XOR	R3, R3			// b9820033
+	MULLD	R4, R3			// b90c0034
+	FLOGR	R4, R2			// b9830024
+	RET				// 07fe
@@ -0,0 +1,28 @@
+// Input for TestSymABIs. The definitions and references
+// it finds are in amd64.symabis, after those of amd64b.s.
+
+#define NOSPLIT 4
+
+DATA	·table+0(SB)/8, $·f(SB)
+DATA	·table+8(SB)/8, $·g+8(SB)
+DATA	local<>+0(SB)/8, $local2<>(SB)
+GLOBL	·table(SB), 8, $16
+
+TEXT	·f(SB), NOSPLIT, $0-8
+	FUNCDATA	$0, ·f_args(SB)
+	PCDATA	$0, $-1
+	MOVQ	$·g(SB), AX
+	CALL	runtime·entersyscall(SB)
+	CALL	AX
+	JMP	·h(SB)
+	JEQ	2(PC)
+	RET
+
+TEXT	·g<>(SB), NOSPLIT, $0
+loop:
+	JMP	loop
+	MOVQ	·data(SB), AX
+	LEAQ	·data+16(SB), AX
+	MOVQ	16(AX), AX
+	CALL	*·fnptr(SB)
+	RET
@@ -0,0 +1,4 @@
+// Input for TestSymABIs, assembled before amd64a.s.
+
+TEXT	·h(SB), 0, $0
+	JMP	runtime∕internal∕atomic·Load(SB)
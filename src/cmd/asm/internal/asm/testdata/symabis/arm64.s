@@ -0,0 +1,11 @@
+// Input for TestSymABIs. The definitions and references
+// it finds are in arm64.symabis.
+
+TEXT	·f(SB), 0, $-8
+	BL	·g(SB)
+	B	runtime·abort(SB)
+	CBZ	R0, done
+	MOVD	$·g(SB), R1
+	BL	(R1)
+done:
+	RET
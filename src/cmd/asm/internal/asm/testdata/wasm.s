@@ -0,0 +1,16 @@
+// This input is assembled by TestWasmEndToEnd. The wasm backend
+// lays out a function as a whole, so the test checks that the bytes
+// in the comments appear in order in the function's code.
+
+TEXT	foo(SB), 7, $0
+	Get	SP			// 2302
+	I64Load	$8			// 290308
+	I64Const	$5		// 4205
+	I64Add				// 7c
+	I64Const	$-2		// 427e
+	I64Mul				// 7e
+	Set	R1			// 2101
+	Get	SP			// 2302
+	Get	R1			// 2001
+	I64Store	$16		// 370310
+	RET
@@ -0,0 +1,297 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lex
+
+import (
+	"strconv"
+	"text/scanner"
+)
+
+// condition reads the expression of an #if or #elif directive to the
+// end of the line and reports whether its value is nonzero.
+func (in *Input) condition(directive string) bool {
+	e := &expr{in: in, directive: directive, tokens: in.condTokens(directive)}
+	x := e.cond(true)
+	if e.pos < len(e.tokens) {
+		e.unexpected(e.tokens[e.pos])
+	}
+	return x != 0
+}
+
+// condTokens reads the rest of the line for an #if or #elif directive,
+// expands the macros in it and replaces each use of defined with 1 or 0.
+func (in *Input) condTokens(directive string) []Token {
+	var tokens []Token
+	tok := in.Stack.Next()
+	// As in Next, give up after 100 macro invocations without a token.
+	for nesting := 0; tok != '\n'; {
+		if tok == scanner.EOF {
+			in.Error("missing newline after", directive)
+		}
+		if tok == scanner.Ident {
+			name := in.Stack.Text()
+			if name == "defined" {
+				tokens = append(tokens, in.defined())
+				nesting = 0
+				tok = in.Stack.Next()
+				continue
+			}
+			if macro := in.macros[name]; macro != nil {
+				nesting++
+				if nesting > 100 {
+					in.Error("recursive macro invocation")
+				}
+				site := in.Stack.Origin().Position
+				if macro.args == nil {
+					in.pushExpansion(macro, in.substitute(macro, nil), site)
+					tok = in.Stack.Next()
+					continue
+				}
+				if tok = in.Stack.Next(); tok == '(' {
+					in.invokeWithArgs(macro, site)
+					tok = in.Stack.Next()
+					continue
+				}
+				// Invoked without arguments, the macro is an identifier;
+				// tok holds the token after it.
+				tokens = append(tokens, Make(scanner.Ident, name))
+				nesting = 0
+				continue
+			}
+		}
+		tokens = append(tokens, Make(tok, in.Stack.Text()))
+		nesting = 0
+		tok = in.Stack.Next()
+	}
+	return tokens
+}
+
+// defined reads the operand of defined, X or (X), in an #if or #elif
+// expression and returns a token for 1 if X is a macro or 0 if not.
+func (in *Input) defined() Token {
+	tok := in.Stack.Next()
+	paren := tok == '('
+	if paren {
+		tok = in.Stack.Next()
+	}
+	if tok != scanner.Ident {
+		in.expectText("expected identifier after defined")
+	}
+	_, ok := in.macros[in.Stack.Text()]
+	if paren && in.Stack.Next() != ')' {
+		in.expectText("expected ')' after defined")
+	}
+	if ok {
+		return Make(scanner.Int, "1")
+	}
+	return Make(scanner.Int, "0")
+}
+
+// An expr evaluates the tokens of an #if or #elif expression, which
+// uses the integer operators of C. Identifiers left after expanding
+// macros have the value 0. Operands that are not needed for the
+// result, such as the right side of 0 && 1/0, are parsed but not
+// checked for errors.
+type expr struct {
+	in        *Input
+	directive string
+	tokens    []Token
+	pos       int
+}
+
+// binaryPrec gives the precedence of each binary operator; higher binds tighter.
+var binaryPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+func (e *expr) error(args ...interface{}) {
+	e.in.Error(append([]interface{}{e.directive + ":"}, args...)...)
+}
+
+func (e *expr) unexpected(t Token) {
+	if t.ScanToken == scanner.EOF {
+		e.error("unexpected end of expression")
+	}
+	e.error("unexpected", strconv.Quote(t.text), "in expression")
+}
+
+// next returns the next token, or an EOF token at the end of the expression.
+func (e *expr) next() Token {
+	if e.pos >= len(e.tokens) {
+		return Make(scanner.EOF, "")
+	}
+	e.pos++
+	return e.tokens[e.pos-1]
+}
+
+func (e *expr) expect(tok ScanToken) {
+	if t := e.next(); t.ScanToken != tok {
+		e.unexpected(t)
+	}
+}
+
+// op returns the binary operator at the next tokens, if any,
+// and the number of tokens it occupies.
+func (e *expr) op() (string, int) {
+	if e.pos >= len(e.tokens) {
+		return "", 0
+	}
+	t := e.tokens[e.pos]
+	if e.pos+1 < len(e.tokens) {
+		// The tokenizer splits && and the like into two tokens.
+		switch t.ScanToken {
+		case '&', '|', '=', '!', '<', '>':
+			op := t.text + e.tokens[e.pos+1].text
+			if _, ok := binaryPrec[op]; ok {
+				return op, 2
+			}
+		}
+	}
+	if _, ok := binaryPrec[t.text]; ok && t.ScanToken != scanner.String {
+		return t.text, 1
+	}
+	return "", 0
+}
+
+// cond parses and evaluates a conditional expression, c ? x : y.
+// If eval is not set, the value is not needed.
+func (e *expr) cond(eval bool) int64 {
+	c := e.binary(1, eval)
+	if e.pos >= len(e.tokens) || e.tokens[e.pos].ScanToken != '?' {
+		return c
+	}
+	e.pos++
+	x := e.cond(eval && c != 0)
+	e.expect(':')
+	y := e.cond(eval && c == 0)
+	if c != 0 {
+		return x
+	}
+	return y
+}
+
+// binary parses and evaluates an expression of binary operators
+// with precedence prec or higher.
+func (e *expr) binary(prec int, eval bool) int64 {
+	x := e.unary(eval)
+	for {
+		op, n := e.op()
+		p := binaryPrec[op]
+		if op == "" || p < prec {
+			return x
+		}
+		e.pos += n
+		switch op {
+		case "&&":
+			y := e.binary(p+1, eval && x != 0)
+			x = boolValue(x != 0 && y != 0)
+			continue
+		case "||":
+			y := e.binary(p+1, eval && x == 0)
+			x = boolValue(x != 0 || y != 0)
+			continue
+		}
+		y := e.binary(p+1, eval)
+		if !eval {
+			continue
+		}
+		switch op {
+		case "|":
+			x |= y
+		case "^":
+			x ^= y
+		case "&":
+			x &= y
+		case "==":
+			x = boolValue(x == y)
+		case "!=":
+			x = boolValue(x != y)
+		case "<":
+			x = boolValue(x < y)
+		case "<=":
+			x = boolValue(x <= y)
+		case ">":
+			x = boolValue(x > y)
+		case ">=":
+			x = boolValue(x >= y)
+		case "<<", ">>":
+			if y < 0 || y > 63 {
+				e.error("shift count", y, "out of range")
+			}
+			if op == "<<" {
+				x <<= uint(y)
+			} else {
+				x >>= uint(y)
+			}
+		case "+":
+			x += y
+		case "-":
+			x -= y
+		case "*":
+			x *= y
+		case "/", "%":
+			if y == 0 {
+				e.error("division by zero")
+			}
+			if op == "/" {
+				x /= y
+			} else {
+				x %= y
+			}
+		}
+	}
+}
+
+// unary parses and evaluates a unary expression.
+func (e *expr) unary(eval bool) int64 {
+	t := e.next()
+	switch t.ScanToken {
+	case '+':
+		return e.unary(eval)
+	case '-':
+		return -e.unary(eval)
+	case '~':
+		return ^e.unary(eval)
+	case '!':
+		return boolValue(e.unary(eval) == 0)
+	case '(':
+		x := e.cond(eval)
+		e.expect(')')
+		return x
+	case scanner.Int:
+		x, err := strconv.ParseInt(t.text, 0, 64)
+		if err != nil {
+			e.error("bad integer constant", t.text)
+		}
+		return x
+	case scanner.Char:
+		s, err := strconv.Unquote(t.text)
+		r := []rune(s)
+		if err != nil || len(r) != 1 {
+			e.error("bad rune constant", t.text)
+		}
+		return int64(r[0])
+	case scanner.Ident:
+		return 0
+	}
+	e.unexpected(t)
+	return 0
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -5,6 +5,7 @@
 package lex
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -22,28 +23,67 @@ import (
 // and parses and instantiates macro definitions.
 type Input struct {
 	Stack
+	// WarnFunc, if non-nil, is called for each #warning directive.
+	// Otherwise the warning is printed to standard error.
+	WarnFunc func(*Error)
+
 	includes        []string
+	trimPath        string // prefix to remove from recorded source file paths
+	recoverErrors   bool   // report errors by panicking with an *Error
 	beginningOfLine bool
-	ifdefStack      []bool
+	ifdefStack      []ifdef
 	macros          map[string]*Macro
-	text            string // Text of last token returned by Next.
+	text            string  // Text of last token returned by Next.
+	origin          *Origin // Origin of last token returned by Next.
 	peek            bool
 	peekToken       ScanToken
 	peekText        string
+	peekOrigin      *Origin
 }
 
-// NewInput returns an Input from the given path.
+// NewInput returns an Input from the given path, using the
+// include directories, macros and path prefix given by the
+// -I, -D and -trimpath flags. With -json, it reports errors
+// as NewInputFromSource does.
 func NewInput(name string) *Input {
+	macros, err := predefine(flags.D)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "asm: parsing -D: %v\n", err)
+		flags.Usage()
+	}
+	in := newInput(name, flags.I, macros, *flags.TrimPath)
+	in.recoverErrors = *flags.JSON
+	return in
+}
+
+// NewInputFromSource returns an Input that reads src as the file name.
+// It does not consult the command-line flags: includes, defines and
+// trimPath take the place of -I, -D and -trimpath. Instead of exiting,
+// the Input reports errors by panicking with an *Error, which the
+// caller must recover.
+func NewInputFromSource(name string, src []byte, includes, defines []string, trimPath string) (*Input, error) {
+	macros, err := predefine(defines)
+	if err != nil {
+		return nil, err
+	}
+	in := newInput(name, includes, macros, trimPath)
+	in.recoverErrors = true
+	in.Push(newTokenizer(name, bytes.NewReader(src), nil, trimPath))
+	return in, nil
+}
+
+func newInput(name string, includes []string, macros map[string]*Macro, trimPath string) *Input {
 	return &Input{
 		// include directories: look in source dir, then -I directories.
-		includes:        append([]string{filepath.Dir(name)}, flags.I...),
+		includes:        append([]string{filepath.Dir(name)}, includes...),
+		trimPath:        trimPath,
 		beginningOfLine: true,
-		macros:          predefine(flags.D),
+		macros:          macros,
 	}
 }
 
 // predefine installs the macros set by the -D flag on the command line.
-func predefine(defines flags.MultiFlag) map[string]*Macro {
+func predefine(defines []string) (map[string]*Macro, error) {
 	macros := make(map[string]*Macro)
 	for _, name := range defines {
 		value := "1"
@@ -53,8 +93,7 @@ func predefine(defines flags.MultiFlag) map[string]*Macro {
 		}
 		tokens := Tokenize(name)
 		if len(tokens) != 1 || tokens[0].ScanToken != scanner.Ident {
-			fmt.Fprintf(os.Stderr, "asm: parsing -D: %q is not a valid identifier name\n", tokens[0])
-			flags.Usage()
+			return nil, fmt.Errorf("%q is not a valid identifier name", name)
 		}
 		macros[name] = &Macro{
 			name:   name,
@@ -62,27 +101,66 @@ func predefine(defines flags.MultiFlag) map[string]*Macro {
 			tokens: Tokenize(value),
 		}
 	}
-	return macros
+	return macros, nil
+}
+
+// An Error is an error in the input, such as a malformed directive
+// or a missing #include file. Its origin is that of the token at
+// which the error was found.
+type Error struct {
+	Origin
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
 }
 
 var panicOnError bool // For testing.
 
+// Error reports an error at the most recent token read from the Stack.
 func (in *Input) Error(args ...interface{}) {
+	in.errorAt(in.Stack.Origin(), args...)
+}
+
+// errorAt reports an error at the origin o.
+func (in *Input) errorAt(o *Origin, args ...interface{}) {
 	if panicOnError {
-		panic(fmt.Errorf("%s:%d: %s", in.File(), in.Line(), fmt.Sprintln(args...)))
+		panic(fmt.Errorf("%s:%d: %s", o.File, o.Line, fmt.Sprintln(args...)))
 	}
-	fmt.Fprintf(os.Stderr, "%s:%d: %s", in.File(), in.Line(), fmt.Sprintln(args...))
+	if in.recoverErrors {
+		panic(&Error{Origin: *o, Msg: strings.TrimSuffix(fmt.Sprintln(args...), "\n")})
+	}
+	fmt.Fprintf(os.Stderr, "%s:%d: %s", o.File, o.Line, fmt.Sprintln(args...))
 	os.Exit(1)
 }
 
+// warnAt reports a warning at the origin o.
+func (in *Input) warnAt(o *Origin, args ...interface{}) {
+	w := &Error{Origin: *o, Msg: strings.TrimSuffix(fmt.Sprintln(args...), "\n")}
+	if in.WarnFunc != nil {
+		in.WarnFunc(w)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s:%d: warning: %s\n", w.File, w.Line, w.Msg)
+}
+
 // expectText is like Error but adds "got XXX" where XXX is a quoted representation of the most recent token.
 func (in *Input) expectText(args ...interface{}) {
 	in.Error(append(args, "; got", strconv.Quote(in.Stack.Text()))...)
 }
 
+// An ifdef is the state of an #if, #ifdef or #ifndef directive
+// and of the #elif and #else directives that follow it.
+type ifdef struct {
+	enabled bool // The current branch is enabled.
+	done    bool // A branch has been enabled, or the directive is disabled, so no later branch is.
+	sawElse bool // The current branch is the #else branch.
+}
+
 // enabled reports whether the input is enabled by an ifdef, or is at the top level.
 func (in *Input) enabled() bool {
-	return len(in.ifdefStack) == 0 || in.ifdefStack[len(in.ifdefStack)-1]
+	return len(in.ifdefStack) == 0 || in.ifdefStack[len(in.ifdefStack)-1].enabled
 }
 
 func (in *Input) expectNewline(directive string) {
@@ -97,14 +175,13 @@ func (in *Input) Next() ScanToken {
 		in.peek = false
 		tok := in.peekToken
 		in.text = in.peekText
+		in.origin = in.peekOrigin
 		return tok
 	}
 	// If we cannot generate a token after 100 macro invocations, we're in trouble.
 	// The usual case is caught by Push, below, but be safe.
 	for nesting := 0; nesting < 100; {
-		println("")
 		tok := in.Stack.Next()
-		fmt.Println("input reader:", tok)
 		switch tok {
 		case '#':
 			if !in.beginningOfLine {
@@ -129,6 +206,7 @@ func (in *Input) Next() ScanToken {
 			in.beginningOfLine = tok == '\n'
 			if in.enabled() {
 				in.text = in.Stack.Text()
+				in.origin = in.Stack.Origin()
 				return tok
 			}
 		}
@@ -141,6 +219,10 @@ func (in *Input) Text() string {
 	return in.text
 }
 
+func (in *Input) Origin() *Origin {
+	return in.origin
+}
+
 // hash processes a # preprocessor directive. It reports whether it completes.
 func (in *Input) hash() bool {
 	// We have a '#'; it must be followed by a known word (define, include, etc.).
@@ -153,7 +235,7 @@ func (in *Input) hash() bool {
 		// need to keep track of nested #if[n]defs.
 		// We let #line through because it might affect errors.
 		switch in.Stack.Text() {
-		case "else", "endif", "ifdef", "ifndef", "line":
+		case "elif", "else", "endif", "if", "ifdef", "ifndef", "line":
 			// Press on.
 		default:
 			return false
@@ -162,10 +244,17 @@ func (in *Input) hash() bool {
 	switch in.Stack.Text() {
 	case "define":
 		in.define()
+	case "elif":
+		in.elif()
 	case "else":
 		in.else_()
 	case "endif":
 		in.endif()
+	case "error":
+		o, msg := in.message("#error")
+		in.errorAt(o, msg)
+	case "if":
+		in.if_()
 	case "ifdef":
 		in.ifdef(true)
 	case "ifndef":
@@ -176,6 +265,9 @@ func (in *Input) hash() bool {
 		in.line()
 	case "undef":
 		in.undef()
+	case "warning":
+		o, msg := in.message("#warning")
+		in.warnAt(o, msg)
 	default:
 		in.Error("unexpected token after '#':", in.Stack.Text())
 	}
@@ -196,12 +288,13 @@ func (in *Input) macroName() string {
 // #define processing.
 func (in *Input) define() {
 	name := in.macroName()
+	pos := in.Stack.Origin().Position
 	args, tokens := in.macroDefinition(name)
-	in.defineMacro(name, args, tokens)
+	in.defineMacro(name, args, tokens, pos)
 }
 
 // defineMacro stores the macro definition in the Input.
-func (in *Input) defineMacro(name string, args []string, tokens []Token) {
+func (in *Input) defineMacro(name string, args []string, tokens []Token, pos Position) {
 	if in.macros[name] != nil {
 		in.Error("redefinition of macro:", name)
 	}
@@ -209,6 +302,7 @@ func (in *Input) defineMacro(name string, args []string, tokens []Token) {
 		name:   name,
 		args:   args,
 		tokens: tokens,
+		pos:    pos,
 	}
 }
 
@@ -217,7 +311,6 @@ func (in *Input) defineMacro(name string, args []string, tokens []Token) {
 // formal argument names.
 func (in *Input) macroDefinition(name string) ([]string, []Token) {
 	prevCol := in.Stack.Col()
-	fmt.Println("define-prevcol:", prevCol)
 	tok := in.Stack.Next()
 	if tok == '\n' || tok == scanner.EOF {
 		return nil, nil // No definition for macro
@@ -247,11 +340,22 @@ func (in *Input) macroDefinition(name string) ([]string, []Token) {
 					in.Error("bad syntax in definition for macro:", name)
 				}
 				acceptArg = true
+			case '.':
+				// A final ... makes the macro variadic.
+				if !acceptArg || in.Stack.Next() != '.' || in.Stack.Next() != '.' || in.Stack.Next() != ')' {
+					in.Error("bad variadic argument in definition for macro:", name)
+				}
+				args = append(args, vaArgs)
+				tok = in.Stack.Next() // First token of macro definition.
+				break Loop
 			case scanner.Ident:
 				if !acceptArg {
 					in.Error("bad syntax in definition for macro:", name)
 				}
 				arg := in.Stack.Text()
+				if arg == vaArgs {
+					in.Error(vaArgs, "used as argument name in definition for macro:", name)
+				}
 				if i := lookup(args, arg); i >= 0 {
 					in.Error("duplicate argument", arg, "in definition for macro:", name)
 				}
@@ -274,9 +378,25 @@ func (in *Input) macroDefinition(name string) ([]string, []Token) {
 				in.Error(`can only escape \ or \n in definition for macro:`, name)
 			}
 		}
+		if tok == '#' {
+			// ## pastes together the tokens on either side of it.
+			if tok = in.Stack.Next(); tok == '#' {
+				tokens = append(tokens, Make(paste, "##"))
+				tok = in.Stack.Next()
+			} else {
+				tokens = append(tokens, Make('#', "#"))
+			}
+			continue
+		}
+		if tok == scanner.Ident && in.Stack.Text() == vaArgs && (len(args) == 0 || args[len(args)-1] != vaArgs) {
+			in.Error(vaArgs, "used in definition of non-variadic macro:", name)
+		}
 		tokens = append(tokens, Make(tok, in.Stack.Text()))
 		tok = in.Stack.Next()
 	}
+	if len(tokens) > 0 && (tokens[0].ScanToken == paste || tokens[len(tokens)-1].ScanToken == paste) {
+		in.Error("'##' at either end of definition for macro:", name)
+	}
 	return args, tokens
 }
 
@@ -293,9 +413,10 @@ func lookup(args []string, arg string) int {
 // parameters substituted for the formals.
 // Invoking a macro does not touch the PC/line history.
 func (in *Input) invokeMacro(macro *Macro) {
+	site := in.Stack.Origin().Position
 	// If the macro has no arguments, just substitute the text.
 	if macro.args == nil {
-		in.Push(NewSlice(in.Base(), in.Line(), macro.tokens))
+		in.pushExpansion(macro, in.substitute(macro, nil), site)
 		return
 	}
 	tok := in.Stack.Next()
@@ -304,25 +425,75 @@ func (in *Input) invokeMacro(macro *Macro) {
 		// First, put back the token.
 		in.peekToken = tok
 		in.peekText = in.text
+		in.peekOrigin = in.Stack.Origin()
 		in.peek = true
 		in.Push(NewSlice(in.Base(), in.Line(), []Token{Make(macroName, macro.name)}))
 		return
 	}
+	in.invokeWithArgs(macro, site)
+}
+
+// invokeWithArgs pushes onto the input Stack the expansion of an invocation
+// at site of a macro with arguments. The opening parenthesis has been absorbed.
+func (in *Input) invokeWithArgs(macro *Macro, site Position) {
 	actuals := in.argsFor(macro)
+	// The invocation extends to the closing parenthesis, if it is on the same line.
+	if end := in.Stack.Origin(); end.File == site.File && end.Line == site.Line {
+		site.EndCol = end.EndCol
+	}
+	in.pushExpansion(macro, in.substitute(macro, actuals), site)
+}
+
+// substitute returns the definition of macro with the actual parameters
+// substituted for the formals, and the operands of each ## pasted together.
+// A ## next to an empty argument leaves the other operand as it is.
+func (in *Input) substitute(macro *Macro, actuals map[string][]Token) []Token {
 	var tokens []Token
+	pasting := false  // The previous token of the definition is ##.
+	canPaste := false // The last of tokens is the left operand of a following ##.
 	for _, tok := range macro.tokens {
-		if tok.ScanToken != scanner.Ident {
-			tokens = append(tokens, tok)
+		if tok.ScanToken == paste {
+			pasting = true
 			continue
 		}
-		substitution := actuals[tok.text]
-		if substitution == nil {
-			tokens = append(tokens, tok)
-			continue
+		substitution := []Token{tok}
+		if actual, ok := actuals[tok.text]; ok && tok.ScanToken == scanner.Ident {
+			substitution = actual
+		}
+		if pasting && canPaste && len(substitution) > 0 {
+			last := &tokens[len(tokens)-1]
+			*last = in.pasteTokens(*last, substitution[0])
+			substitution = substitution[1:]
+		} else {
+			canPaste = len(substitution) > 0 || pasting && canPaste
 		}
 		tokens = append(tokens, substitution...)
+		pasting = false
 	}
-	in.Push(NewSlice(in.Base(), in.Line(), tokens))
+	return tokens
+}
+
+// pasteTokens returns the token made by pasting left and right together.
+func (in *Input) pasteTokens(left, right Token) Token {
+	if left.ScanToken == scanner.Ident && (right.ScanToken == scanner.Ident || right.ScanToken == scanner.Int) {
+		// Make has rewritten each · as . and put "" before a leading one,
+		// which belongs only at the start of the pasted identifier.
+		return Token{ScanToken: scanner.Ident, text: left.text + strings.TrimPrefix(right.text, `""`)}
+	}
+	tokens := Tokenize(left.text + right.text)
+	if len(tokens) != 1 {
+		in.Error("pasting", strconv.Quote(left.text), "and", strconv.Quote(right.text), "does not give a valid token")
+	}
+	return tokens[0]
+}
+
+// pushExpansion pushes onto the input Stack a Slice that holds the tokens
+// of the expansion of macro invoked at site.
+func (in *Input) pushExpansion(macro *Macro, tokens []Token, site Position) {
+	s := NewSlice(in.Base(), in.Line(), tokens)
+	s.macro = macro
+	s.site = &site
+	in.Push(s)
 }
 
 // argsFor returns a map from formal name to actual value for this argumented macro invocation.
@@ -340,6 +511,16 @@ func (in *Input) argsFor(macro *Macro) map[string][]Token {
 	// Zero-argument macros are tricky.
 	if len(macro.args) == 0 && len(args) == 1 && args[0] == nil {
 		args = nil
+	} else if n := len(macro.args) - 1; macro.variadic() && len(args) >= n {
+		// The variable arguments, with the commas between them, are __VA_ARGS__.
+		var va []Token
+		for i, arg := range args[n:] {
+			if i > 0 {
+				va = append(va, Make(',', ","))
+			}
+			va = append(va, arg...)
+		}
+		args = append(args[:n], va)
 	} else if len(args) != len(macro.args) {
 		in.Error("wrong arg count for macro", macro.name)
 	}
@@ -374,16 +555,49 @@ func (in *Input) collectArgument(macro *Macro) ([]Token, ScanToken) {
 	}
 }
 
+// pushIfdef starts an #if, #ifdef or #ifndef, whose first branch
+// is enabled if truth is set and the input is enabled.
+func (in *Input) pushIfdef(truth bool) {
+	outer := in.enabled()
+	truth = truth && outer
+	in.ifdefStack = append(in.ifdefStack, ifdef{enabled: truth, done: truth || !outer})
+}
+
 // #ifdef and #ifndef processing.
 func (in *Input) ifdef(truth bool) {
 	name := in.macroName()
 	in.expectNewline("#if[n]def")
+	_, defined := in.macros[name]
+	in.pushIfdef(defined == truth)
+}
+
+// #if processing.
+func (in *Input) if_() {
 	if !in.enabled() {
-		truth = false
-	} else if _, defined := in.macros[name]; !defined {
-		truth = !truth
+		// The expression of a disabled #if need not be valid.
+		in.skipLine()
+		in.pushIfdef(false)
+		return
 	}
-	in.ifdefStack = append(in.ifdefStack, truth)
+	in.pushIfdef(in.condition("#if"))
+}
+
+// #elif processing.
+func (in *Input) elif() {
+	if len(in.ifdefStack) == 0 {
+		in.Error("unmatched #elif")
+	}
+	d := &in.ifdefStack[len(in.ifdefStack)-1]
+	if d.sawElse {
+		in.Error("#elif after #else")
+	}
+	if d.done {
+		in.skipLine()
+		d.enabled = false
+		return
+	}
+	d.enabled = in.condition("#elif")
+	d.done = d.enabled
 }
 
 // #else processing
@@ -392,9 +606,11 @@ func (in *Input) else_() {
 	if len(in.ifdefStack) == 0 {
 		in.Error("unmatched #else")
 	}
-	if len(in.ifdefStack) == 1 || in.ifdefStack[len(in.ifdefStack)-2] {
-		in.ifdefStack[len(in.ifdefStack)-1] = !in.ifdefStack[len(in.ifdefStack)-1]
+	d := &in.ifdefStack[len(in.ifdefStack)-1]
+	if d.sawElse {
+		in.Error("#else after #else")
 	}
+	d.enabled, d.done, d.sawElse = !d.done, true, true
 }
 
 // #endif processing.
@@ -406,6 +622,34 @@ func (in *Input) endif() {
 	in.ifdefStack = in.ifdefStack[:len(in.ifdefStack)-1]
 }
 
+// skipLine discards the rest of the line.
+func (in *Input) skipLine() {
+	for tok := in.Stack.Next(); tok != '\n' && tok != scanner.EOF; tok = in.Stack.Next() {
+	}
+}
+
+// message reads the rest of the line as the message of an #error or
+// #warning directive and returns it, after the directive, with the
+// origin of the directive. Tokens of the message are separated by a
+// space where the source separates them.
+func (in *Input) message(directive string) (*Origin, string) {
+	o := in.Stack.Origin()
+	msg := directive
+	end := -1
+	for tok := in.Stack.Next(); tok != '\n' && tok != scanner.EOF; tok = in.Stack.Next() {
+		t := in.Stack.Origin()
+		if t.Col == 0 || t.Col != end {
+			msg += " "
+		}
+		msg += in.Stack.Text()
+		end = t.EndCol
+		if t.File == o.File && t.Line == o.Line {
+			o.EndCol = t.EndCol
+		}
+	}
+	return o, msg
+}
+
 // #include processing.
 func (in *Input) include() {
 	// Find and parse string.
@@ -417,7 +661,7 @@ func (in *Input) include() {
 	if err != nil {
 		in.Error("unquoting include file name: ", err)
 	}
-	in.expectNewline("#include")
+	site := in.Stack.Origin().Position
 	// Push tokenizer for file onto stack.
 	fd, err := os.Open(name)
 	if err != nil {
@@ -431,7 +675,10 @@ func (in *Input) include() {
 			in.Error("#include:", err)
 		}
 	}
-	in.Push(NewTokenizer(name, fd, fd))
+	in.expectNewline("#include")
+	t := newTokenizer(name, fd, fd, in.trimPath)
+	t.includedAt = &site
+	in.Push(t)
 }
 
 // #line processing.
@@ -458,7 +705,7 @@ func (in *Input) line() {
 		in.Error("unexpected token at end of #line: ", tok)
 	}
 	pos := src.MakePos(in.Base(), uint(in.Line())+1, 1) // +1 because #line nnn means line nnn starts on next line
-	in.Stack.SetBase(src.NewLinePragmaBase(pos, file, objabi.AbsFile(objabi.WorkingDir(), file, *flags.TrimPath), uint(line), 1))
+	in.Stack.SetBase(src.NewLinePragmaBase(pos, file, objabi.AbsFile(objabi.WorkingDir(), file, in.trimPath), uint(line), 1))
 }
 
 // #undef processing
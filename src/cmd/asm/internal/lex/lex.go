@@ -27,6 +27,7 @@ const (
 	ARR                                // -> Used on ARM for shift type 3, arithmetic right shift.
 	ROT                                // @> Used on ARM for shift type 4, rotate right.
 	macroName                          // name of macro that should not be expanded
+	paste                              // ## in a macro definition
 )
 
 // IsRegisterShift reports whether the token is one of the ARM register shift operators.
@@ -64,7 +65,7 @@ func NewLexer(name string) TokenReader {
 	if err != nil {
 		log.Fatalf("%s\n", err)
 	}
-	input.Push(NewTokenizer(name, fd, fd))
+	input.Push(newTokenizer(name, fd, fd, input.trimPath))
 	return input
 }
 
@@ -90,6 +91,8 @@ type TokenReader interface {
 	Line() int
 	// Col reports the source column number of the token.
 	Col() int
+	// Origin reports where the token was found, for diagnostics.
+	Origin() *Origin
 	// Close does any teardown required.
 	Close()
 }
@@ -98,7 +101,8 @@ type TokenReader interface {
 // A macro is stored as a sequence of Tokens with spaces stripped.
 type Token struct {
 	ScanToken
-	text string
+	text   string
+	origin *Origin
 }
 
 // Make returns a Token with the given rune (ScanToken) and text representation.
@@ -117,16 +121,63 @@ func (l Token) String() string {
 	return l.text
 }
 
+// Origin returns where the token was found, or nil if that is not known.
+func (l Token) Origin() *Origin {
+	return l.origin
+}
+
+// WithOrigin returns a copy of the token that records o as where it was found.
+func (l Token) WithOrigin(o *Origin) Token {
+	l.origin = o
+	return l
+}
+
+// A Position is a location in the source. Col and EndCol are the columns,
+// counted in characters from 1, of the first character of a token and of
+// the character after its end; they are 0 if not known.
+type Position struct {
+	File   string
+	Line   int
+	Col    int
+	EndCol int
+}
+
+// An Origin describes where a token was found: its position, and the macro
+// expansions and #include directives that brought it there, innermost first.
+// A token produced by a macro expansion is positioned at the invocation of
+// the outermost macro.
+type Origin struct {
+	Position
+	Context []Frame
+}
+
+// A Frame is one step in the context of an Origin: a macro expansion,
+// positioned at the macro's definition, or an #include directive.
+type Frame struct {
+	Position
+	Msg string
+}
+
 // A Macro represents the definition of a #defined macro.
 type Macro struct {
 	name   string   // The #define name.
 	args   []string // Formal arguments.
 	tokens []Token  // Body of macro.
+	pos    Position // Where the macro was defined; zero for -D.
+}
+
+// vaArgs is the name of the formal of a variadic macro that
+// holds its variable arguments, written ... in its definition.
+const vaArgs = "__VA_ARGS__"
+
+// variadic reports whether the macro takes a variable number of arguments.
+func (m *Macro) variadic() bool {
+	return len(m.args) > 0 && m.args[len(m.args)-1] == vaArgs
 }
 
 // Tokenize turns a string into a list of Tokens; used to parse the -D flag and in tests.
 func Tokenize(str string) []Token {
-	t := NewTokenizer("command line", strings.NewReader(str), nil)
+	t := newTokenizer("command line", strings.NewReader(str), nil, "")
 	var tokens []Token
 	for {
 		tok := t.Next()
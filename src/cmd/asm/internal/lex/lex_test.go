@@ -6,6 +6,7 @@ package lex
 
 import (
 	"bytes"
+	"reflect"
 	"strings"
 	"testing"
 	"text/scanner"
@@ -244,6 +245,96 @@ var lexTests = []lexTest{
 		),
 		"THIS.\n",
 	},
+	{
+		"#if expression",
+		lines(
+			"#define A 2",
+			"#if A*3 == 6 && defined(A) && !defined B",
+			"#define C 1",
+			"#else",
+			"#define C 0",
+			"#endif",
+			"C",
+		),
+		"1.\n",
+	},
+	{
+		"#if constants and operators",
+		lines(
+			"#define SHIFT(x) ((x) << 4)",
+			"#if 0x10 == 16 && 010 == 8 && 'a' == 97 && -1 < 0 && (7 % 4 | 8) == 11 && ~0 == -1",
+			"#if SHIFT(1) >= 16 ? SHIFT(2) == 32 : 0",
+			"yes",
+			"#endif",
+			"#endif",
+		),
+		"yes.\n",
+	},
+	{
+		"#elif",
+		lines(
+			"#define GOARCH_arm64",
+			"#if defined(GOARCH_amd64)",
+			"#define R AX",
+			"#elif defined(GOARCH_arm64) || defined(GOARCH_arm)",
+			"#define R R0",
+			"#elif 1",
+			"#define R R1",
+			"#else",
+			"#define R unknown",
+			"#endif",
+			"R",
+		),
+		"R0.\n",
+	},
+	{
+		"#if unneeded operands",
+		lines(
+			"#if 0 && 1/0",
+			"no",
+			"#elif 1 || 1/0",
+			"yes",
+			"#elif 1/0",
+			"no",
+			"#endif",
+		),
+		"yes.\n",
+	},
+	{
+		"disabled #if",
+		lines(
+			"#ifdef A",
+			"#if 1 +",
+			"#elif )(",
+			"#else",
+			"#endif",
+			"#endif",
+			"X",
+		),
+		"X.\n",
+	},
+	{
+		"variadic macro",
+		lines(
+			"#define V(op, ...) op __VA_ARGS__",
+			"V(MOVQ, AX, BX)",
+			"V(RET)",
+		),
+		"MOVQ.AX.,.BX.\n.RET.\n",
+	},
+	{
+		"token pasting",
+		lines(
+			"#define CAT(a, b) a ## b",
+			"#define REG(n) R ## n",
+			"#define SYM(pkg, name) pkg ## · ## name",
+			"#define LOCAL(name) · ## name",
+			"#define SHL <##<",
+			"CAT(FOO, BAR) REG(12) CAT(1, 2) CAT(, X) CAT(Y, )",
+			"SYM(runtime, memmove) LOCAL(f) SHL",
+		),
+		"FOOBAR.R12.12.X.Y.\n.runtime.memmove.\"\".f.<<.\n",
+	},
 	/* This one fails. See comment in Slice.Col.
 	   {
 	       "nested #define with args",
@@ -339,6 +430,44 @@ func TestBadLex(t *testing.T) {
 	}
 }
 
+var preprocessorErrorTests = []struct {
+	input string
+	error string
+}{
+	{"#if 1 +\n", "x.s:1: #if: unexpected end of expression"},
+	{"#if 1 (\n", `x.s:1: #if: unexpected "(" in expression`},
+	{"#if 1/0\n#endif\n", "x.s:1: #if: division by zero"},
+	{"#if 1 << 64\n#endif\n", "x.s:1: #if: shift count 64 out of range"},
+	{"#if defined(A\n", `x.s:1: expected ')' after defined ; got "\n"`},
+	{"#elif 1\n", "x.s:1: unmatched #elif"},
+	{"#if 1\n#else\n#elif 1\n#endif\n", "x.s:3: #elif after #else"},
+	{"#if 1\n#else\n#else\n#endif\n", "x.s:3: #else after #else"},
+	{"MOVQ\n#error GOARCH  not supported\n", "x.s:2: #error GOARCH not supported"},
+	{"#define V(..., x) x\n", "x.s:1: bad variadic argument in definition for macro: V"},
+	{"#define V(x, y, ...) x\nV(1)\n", "x.s:2: wrong arg count for macro V"},
+	{"#define V(x) x, __VA_ARGS__\n", "x.s:1: __VA_ARGS__ used in definition of non-variadic macro: V"},
+	{"#define V __VA_ARGS__\n", "x.s:1: __VA_ARGS__ used in definition of non-variadic macro: V"},
+	{"#define A(x) x ##\n", "x.s:1: '##' at either end of definition for macro: A"},
+	{"#define P(a, b) a ## b\nP(+, x)\n", `x.s:2: pasting "+" and "x" does not give a valid token`},
+}
+
+// TestPreprocessorErrors checks the errors, with their positions,
+// for bad #if and #elif expressions, macros and directives.
+func TestPreprocessorErrors(t *testing.T) {
+	for _, test := range preprocessorErrorTests {
+		input := NewInput("x.s")
+		input.Push(NewTokenizer("x.s", strings.NewReader(test.input), nil))
+		err := firstError(input)
+		if err == nil {
+			t.Errorf("%q: got no error", test.input)
+			continue
+		}
+		if got := strings.TrimSuffix(err.Error(), "\n"); got != test.error {
+			t.Errorf("%q: got error %q expected %q", test.input, got, test.error)
+		}
+	}
+}
+
 // firstError returns the first error value triggered by the input.
 func firstError(input *Input) (err error) {
 	panicOnError = true
@@ -360,3 +489,64 @@ func firstError(input *Input) (err error) {
 		}
 	}
 }
+
+// TestOrigin checks the positions that Input reports for tokens
+// from the source and from macro expansions.
+func TestOrigin(t *testing.T) {
+	src := lines(
+		"#define A(x) x+1",
+		"#define B A(2)",
+		"MOVQ\tA(10), BX",
+		"B",
+	)
+	input := NewInput("x.s")
+	input.Push(NewTokenizer("x.s", strings.NewReader(src), nil))
+	a := Frame{Position{"x.s", 1, 9, 10}, "in expansion of macro A"}
+	b := Frame{Position{"x.s", 2, 9, 10}, "in expansion of macro B"}
+	want := []struct {
+		text   string
+		origin Origin
+	}{
+		{"MOVQ", Origin{Position: Position{"x.s", 3, 1, 5}}},
+		{"10", Origin{Position{"x.s", 3, 6, 11}, []Frame{a}}},
+		{"+", Origin{Position{"x.s", 3, 6, 11}, []Frame{a}}},
+		{"1", Origin{Position{"x.s", 3, 6, 11}, []Frame{a}}},
+		{",", Origin{Position: Position{"x.s", 3, 11, 12}}},
+		{"BX", Origin{Position: Position{"x.s", 3, 13, 15}}},
+		{"2", Origin{Position{"x.s", 4, 1, 2}, []Frame{a, b}}},
+		{"+", Origin{Position{"x.s", 4, 1, 2}, []Frame{a, b}}},
+		{"1", Origin{Position{"x.s", 4, 1, 2}, []Frame{a, b}}},
+	}
+	var i int
+	for tok := input.Next(); tok != scanner.EOF; tok = input.Next() {
+		if tok == '\n' {
+			continue
+		}
+		if i >= len(want) {
+			t.Fatalf("unexpected token %q", input.Text())
+		}
+		if input.Text() != want[i].text || !reflect.DeepEqual(*input.Origin(), want[i].origin) {
+			t.Errorf("token %q at %+v, want %q at %+v", input.Text(), *input.Origin(), want[i].text, want[i].origin)
+		}
+		i++
+	}
+	if i < len(want) {
+		t.Errorf("got %d tokens, want %d", i, len(want))
+	}
+}
+
+func TestWarning(t *testing.T) {
+	input := NewInput("x.s")
+	input.Push(NewTokenizer("x.s", strings.NewReader("#warning old  code\nX\n"), nil))
+	var warnings []*Error
+	input.WarnFunc = func(w *Error) {
+		warnings = append(warnings, w)
+	}
+	if result := drain(input); result != "X.\n" {
+		t.Errorf("got %q expected %q", result, "X.\n")
+	}
+	want := []*Error{{Origin: Origin{Position: Position{"x.s", 1, 2, 19}}, Msg: "#warning old code"}}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings %+v, want %+v", warnings, want)
+	}
+}
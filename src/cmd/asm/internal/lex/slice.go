@@ -16,6 +16,8 @@ type Slice struct {
 	base   *src.PosBase
 	line   int
 	pos    int
+	macro  *Macro    // The macro whose expansion this is, if any.
+	site   *Position // Where the macro was invoked.
 }
 
 func NewSlice(base *src.PosBase, line int, tokens []Token) *Slice {
@@ -70,5 +72,18 @@ func (s *Slice) Col() int {
 	return s.pos
 }
 
+// Origin reports the invocation of the macro, if known, as the position
+// of the token, since the tokens of an expansion are not in the source.
+func (s *Slice) Origin() *Origin {
+	o := &Origin{Position: Position{File: s.File(), Line: s.line}}
+	if s.site != nil {
+		o.Position = *s.site
+	}
+	if s.macro != nil {
+		o.Context = []Frame{{Position: s.macro.pos, Msg: "in expansion of macro " + s.macro.name}}
+	}
+	return o
+}
+
 func (s *Slice) Close() {
 }
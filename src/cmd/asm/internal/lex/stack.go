@@ -57,5 +57,15 @@ func (s *Stack) Col() int {
 	return s.tr[len(s.tr)-1].Col()
 }
 
+// Origin reports the position of the token in the top reader, with the
+// context of the readers below it.
+func (s *Stack) Origin() *Origin {
+	o := s.tr[len(s.tr)-1].Origin()
+	for i := len(s.tr) - 2; i >= 0; i-- {
+		o.Context = append(o.Context, s.tr[i].Origin().Context...)
+	}
+	return o
+}
+
 func (s *Stack) Close() { // Unused.
 }
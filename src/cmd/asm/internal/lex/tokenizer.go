@@ -14,7 +14,6 @@ import (
 	"cmd/asm/internal/flags"
 	"cmd/internal/objabi"
 	"cmd/internal/src"
-	"fmt"
 )
 
 // A Tokenizer is a simple wrapping of text/scanner.Scanner, configured
@@ -26,9 +25,17 @@ type Tokenizer struct {
 	base *src.PosBase
 	line int
 	file *os.File // If non-nil, file descriptor to close.
+	// includedAt is the position of the #include of the file, if any.
+	includedAt *Position
 }
 
 func NewTokenizer(name string, r io.Reader, file *os.File) *Tokenizer {
+	return newTokenizer(name, r, file, *flags.TrimPath)
+}
+
+// newTokenizer is like NewTokenizer but takes the prefix
+// to remove from the recorded file name as an argument.
+func newTokenizer(name string, r io.Reader, file *os.File, trimPath string) *Tokenizer {
 	var s scanner.Scanner
 	s.Init(r)
 	// Newline is like a semicolon; other space characters are fine.
@@ -44,7 +51,7 @@ func NewTokenizer(name string, r io.Reader, file *os.File) *Tokenizer {
 	s.IsIdentRune = isIdentRune
 	return &Tokenizer{
 		s:    &s,
-		base: src.NewFileBase(name, objabi.AbsFile(objabi.WorkingDir(), name, *flags.TrimPath)),
+		base: src.NewFileBase(name, objabi.AbsFile(objabi.WorkingDir(), name, trimPath)),
 		line: 1,
 		file: file,
 	}
@@ -101,6 +108,25 @@ func (t *Tokenizer) Col() int {
 	return t.s.Pos().Column
 }
 
+// Origin reports the position of the token, using the scanner's
+// record of where it starts and of where it stopped reading.
+func (t *Tokenizer) Origin() *Origin {
+	o := &Origin{
+		Position: Position{
+			File: t.File(),
+			Line: t.s.Position.Line,
+			Col:  t.s.Position.Column,
+		},
+	}
+	if end := t.s.Pos(); end.Line == o.Line && end.Column > o.Col {
+		o.EndCol = end.Column
+	}
+	if t.includedAt != nil {
+		o.Context = []Frame{{Position: *t.includedAt, Msg: "included from here"}}
+	}
+	return o
+}
+
 func (t *Tokenizer) Next() ScanToken {
 	s := t.s
 	for {
@@ -113,7 +139,6 @@ func (t *Tokenizer) Next() ScanToken {
 		// TODO: If we ever have //go: comments in assembly, will need to keep them here.
 		// For now, just discard all comments.
 	}
-	fmt.Println("text-intokenizer:", s.TokenText(), ",pos:", s.Pos(), ",peek:", s.Peek())
 	switch t.tok {
 	case '\n':
 		t.line++
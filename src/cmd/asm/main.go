@@ -6,6 +6,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -27,7 +28,6 @@ func main() {
 	log.SetFlags(log.LstdFlags)
 	log.SetPrefix("asm: ")
 	GOARCH := objabi.GOARCH
-	log.Println(GOARCH)
 
 	architecture := arch.Set(GOARCH)
 	if architecture == nil {
@@ -37,7 +37,6 @@ func main() {
 	flags.Parse()
 
 	ctxt := obj.Linknew(architecture.LinkArch)
-	log.Printf("ctxt=%+v\narchitecture=%+v", ctxt, architecture)
 
 	if *flags.PrintOut {
 		ctxt.Debugasm = 1
@@ -69,26 +68,30 @@ func main() {
 
 	var ok, diag bool
 	var failedFile string
-	for i, f := range flag.Args() {
-		log.Println("arg-", i, f)
+	var diags []asm.Diagnostic // All the diagnostics, for -json.
+	for _, f := range flag.Args() {
 		lexer := lex.NewLexer(f)
 		parser := asm.NewParser(ctxt, architecture, lexer)
 
 		ctxt.DiagFunc = func(format string, args ...interface{}) {
 			diag = true
+			if *flags.JSON {
+				diags = append(diags, asm.Diagnostic{File: f, Msg: fmt.Sprintf(format, args...)})
+				return
+			}
 			log.Printf(format, args...)
 		}
 		if *flags.SymABIs {
-			// 	ok = parser.ParseSymABIs(buf)
+			ok = parser.ParseSymABIs(buf)
 		} else {
 			pList := new(obj.Plist)
 			pList.Firstpc, ok = parser.Parse()
-			log.Printf("firstpc[%v],ok[%v]\n", pList.Firstpc, ok)
 			// reports errors to parser.Errorf
 			if ok {
 				obj.Flushplist(ctxt, pList, nil, "")
 			}
 		}
+		diags = append(diags, parser.Diagnostics()...)
 		if !ok {
 			failedFile = f
 			break
@@ -98,10 +101,20 @@ func main() {
 	if ok && !*flags.SymABIs {
 		obj.WriteObjFile(ctxt, buf)
 	}
+	if *flags.JSON && len(diags) > 0 {
+		data, err := json.MarshalIndent(diags, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stderr.Write(append(data, '\n'))
+	}
 	if !ok || diag {
-		if failedFile != "" {
+		switch {
+		case *flags.JSON:
+			// Standard error holds only the diagnostics.
+		case failedFile != "":
 			log.Printf("assembly of %s failed", failedFile)
-		} else {
+		default:
 			log.Print("assembly failed")
 		}
 		out.Close()
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+ * Go archives.
+ *
+ * A Go archive is a Unix ar file. Its first member is usually
+ * __.PKGDEF, the export data of the package, followed by the
+ * compiled Go object, _go_.o, and any objects packed alongside it,
+ * such as the output of the assembler.
+ *
+ * The reader accepts member names longer than 16 bytes in both the
+ * BSD form, #1/n, with the name stored at the start of the member
+ * data, and the GNU form, which keeps long names in a // member and
+ * refers to them as /offset. The Go linker reads neither: it takes
+ * every member after __.PKGDEF for an object. So the writer truncates
+ * long names to 16 bytes, as cmd/pack does, and reports an error
+ * when that makes two names the same.
+ *
+ * This is the only copy of this code. cmd/dist cannot share it with
+ * the other commands through a package, since it must build with
+ * Go 1.4, whose go command resolves imports in the bootstrap GOROOT.
+ */
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+	arNameSize   = 16
+	arBSDPrefix  = "#1/"
+)
+
+// An arMember is one member of an archive.
+type arMember struct {
+	name     string
+	uid, gid int
+	mode     os.FileMode
+	data     []byte
+}
+
+// kind reports what kind of member m is: pkgdef for the export data,
+// goobj for a Go object file, whatever its name, or native for any
+// other object file.
+func (m *arMember) kind() string {
+	switch {
+	case m.name == "__.PKGDEF":
+		return "pkgdef"
+	case bytes.HasPrefix(m.data, []byte("go object ")):
+		return "goobj"
+	}
+	return "native"
+}
+
+// readArchive reads the members of the archive in file.
+func readArchive(file string) ([]*arMember, error) {
+	members, err := parseArchive([]byte(readfile(file)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return members, nil
+}
+
+// parseArchive parses the members of the archive data.
+func parseArchive(data []byte) ([]*arMember, error) {
+	if !bytes.HasPrefix(data, []byte(arMagic)) {
+		return nil, fmt.Errorf("missing !<arch> header")
+	}
+	var members []*arMember
+	var gnuNames []byte
+	off := len(arMagic)
+	for off < len(data) {
+		bad := func(format string, args ...interface{}) error {
+			return fmt.Errorf("malformed archive at offset %d: %s", off, fmt.Sprintf(format, args...))
+		}
+		if len(data)-off < arHeaderSize {
+			return nil, bad("truncated member header")
+		}
+		hdr := data[off : off+arHeaderSize]
+		if string(hdr[58:60]) != "`\n" {
+			return nil, bad("bad member header terminator")
+		}
+		field := func(i, j int) string {
+			return strings.TrimRight(string(hdr[i:j]), " ")
+		}
+		// An empty field, as written by some tools for the ids, counts as zero.
+		var nums [4]int64
+		for i, f := range [][3]int{{28, 34, 10}, {34, 40, 10}, {40, 48, 8}, {48, 58, 10}} {
+			if s := field(f[0], f[1]); s != "" {
+				n, err := strconv.ParseInt(s, f[2], 64)
+				if err != nil || n < 0 {
+					return nil, bad("bad number %q", s)
+				}
+				nums[i] = n
+			}
+		}
+		size := nums[3]
+		start := off + arHeaderSize
+		if size > int64(len(data)-start) {
+			return nil, bad("member size %d extends past end of archive", size)
+		}
+		m := &arMember{
+			uid:  int(nums[0]),
+			gid:  int(nums[1]),
+			mode: os.FileMode(nums[2]) & os.ModePerm,
+			data: data[start : start+int(size)],
+		}
+
+		name := field(0, 16)
+		switch {
+		case name == "//":
+			// GNU table of long names, used by later members.
+			gnuNames = m.data
+			m = nil
+		case name == "/" || name == "/SYM64/":
+			// GNU symbol table; Go archives never need it.
+			m = nil
+		case strings.HasPrefix(name, arBSDPrefix):
+			n, err := strconv.Atoi(name[len(arBSDPrefix):])
+			if err != nil || n < 0 || int64(n) > size {
+				return nil, bad("bad long name %q", name)
+			}
+			m.name = string(m.data[:n])
+			m.data = m.data[n:]
+		case strings.HasPrefix(name, "/"):
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n < 0 || n >= len(gnuNames) {
+				return nil, bad("bad long name reference %q", name)
+			}
+			long := gnuNames[n:]
+			if i := bytes.IndexByte(long, '\n'); i >= 0 {
+				long = long[:i]
+			}
+			m.name = strings.TrimSuffix(string(long), "/")
+		default:
+			m.name = strings.TrimSuffix(name, "/")
+		}
+		if m != nil {
+			if m.name == "" {
+				return nil, bad("empty member name")
+			}
+			members = append(members, m)
+		}
+
+		off = start + int(size)
+		if size&1 != 0 && off < len(data) {
+			if data[off] != '\n' && data[off] != 0 {
+				return nil, bad("bad padding byte")
+			}
+			off++
+		}
+	}
+	return members, nil
+}
+
+// writeArchive returns the archive holding members, with zero
+// modification times so that builds are reproducible.
+// Names longer than 16 bytes are truncated, as cmd/pack does.
+// It returns an error if a name cannot be represented, or if
+// truncation gives two members the same name.
+func writeArchive(members []*arMember) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(arMagic)
+	seen := make(map[string]string)
+	for _, m := range members {
+		name := m.name
+		for len(name) > arNameSize {
+			_, n := utf8.DecodeLastRuneInString(name)
+			name = name[:len(name)-n]
+		}
+		if name == "" || strings.ContainsAny(name, "\n\x00") || strings.HasPrefix(name, "/") || strings.HasPrefix(name, arBSDPrefix) || strings.HasSuffix(name, "/") || strings.HasSuffix(name, " ") {
+			return nil, fmt.Errorf("invalid archive member name %q", m.name)
+		}
+		if other, ok := seen[name]; ok && other != m.name {
+			return nil, fmt.Errorf("archive member names %q and %q are both truncated to %q", other, m.name, name)
+		}
+		seen[name] = m.name
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, m.uid, m.gid, uint32(m.mode), len(m.data))
+		buf.Write(m.data)
+		if len(m.data)&1 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	members := []*arMember{
+		{name: "__.PKGDEF", mode: 0644, data: []byte("go object linux amd64 devel\n$$B\nexport data\n$$\n")},
+		{name: "_go_.o", mode: 0644, data: []byte("go object linux amd64 devel\n\n!\nobj")},
+		{name: "asm_amd64.o", mode: 0644, data: []byte("odd")},
+		{name: "with space.o", mode: 0644, data: []byte("\x7fELF")},
+	}
+	data, err := writeArchive(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseArchive(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(members) {
+		t.Fatalf("got %d members, want %d", len(got), len(members))
+	}
+	kinds := []string{"pkgdef", "goobj", "native", "native"}
+	for i, m := range got {
+		want := members[i]
+		if m.name != want.name || !bytes.Equal(m.data, want.data) || m.mode != 0644 {
+			t.Errorf("member %d = %q %q %v, want %q %q 0644", i, m.name, m.data, m.mode, want.name, want.data)
+		}
+		if m.kind() != kinds[i] {
+			t.Errorf("member %d (%s): kind %s, want %s", i, m.name, m.kind(), kinds[i])
+		}
+	}
+
+	// Writing what was read must give the same bytes.
+	again, err := writeArchive(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("rewritten archive differs:\n%q\n%q", again, data)
+	}
+}
+
+func TestArchiveHeader(t *testing.T) {
+	// The header must match what the compiler and cmd/pack write.
+	// Names longer than 16 bytes, like those of the runtime's
+	// assembly objects, are cut to 16 bytes, dropping whole
+	// characters, with the member data following the header directly.
+	data, err := writeArchive([]*arMember{
+		{name: "asm.o", mode: 0644, data: []byte("x")},
+		{name: "sys_linux_amd64.o", mode: 0644, data: []byte("\x7fELF")},
+		{name: "abcdefghijklmnoé.o", mode: 0644, data: []byte("y")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := arMagic +
+		"asm.o           0           0     0     644     1         `\nx\x00" +
+		"sys_linux_amd64.0           0     0     644     4         `\n\x7fELF" +
+		"abcdefghijklmno 0           0     0     644     1         `\ny\x00"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestArchiveWriteErrors(t *testing.T) {
+	tests := []struct {
+		names []string
+		err   string
+	}{
+		{[]string{""}, `invalid archive member name ""`},
+		{[]string{"a\nb"}, "invalid archive member name"},
+		{[]string{"/0"}, "invalid archive member name"},
+		{[]string{"#1/3"}, "invalid archive member name"},
+		{[]string{"dir/"}, "invalid archive member name"},
+		{[]string{"x.o "}, "invalid archive member name"},
+		{[]string{"sys_linux_amd64.o", "sys_linux_amd64.s.o"},
+			`archive member names "sys_linux_amd64.o" and "sys_linux_amd64.s.o" are both truncated to "sys_linux_amd64."`},
+		{[]string{"sys_linux_amd64.", "sys_linux_amd64.o"}, "are both truncated to"},
+	}
+	for _, tt := range tests {
+		var members []*arMember
+		for _, name := range tt.names {
+			members = append(members, &arMember{name: name, mode: 0644})
+		}
+		_, err := writeArchive(members)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("writeArchive(%q) = %v, want error containing %q", tt.names, err, tt.err)
+		}
+	}
+
+	// The same name twice is not a collision.
+	if _, err := writeArchive([]*arMember{{name: "a.o"}, {name: "a.o"}}); err != nil {
+		t.Errorf("writeArchive with a repeated name: %v", err)
+	}
+}
+
+// arEntry returns an archive member written the way GNU ar and
+// other tools write them, with ' ' padding and a '\n' pad byte.
+func arEntry(name, data string) string {
+	h := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data)) + data
+	if len(data)&1 != 0 {
+		h += "\n"
+	}
+	return h
+}
+
+func TestArchiveLongNames(t *testing.T) {
+	data := arMagic +
+		arEntry("/", "\x00\x00\x00\x00") +
+		arEntry("//", "a_very_long_object_file_name.o/\nshort.o/\n") +
+		arEntry("/0", "gnu") +
+		arEntry("short.o/", "short") +
+		arEntry("#1/19", "another_long_name.obsd")
+	members, err := parseArchive([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range members {
+		names = append(names, m.name+"="+string(m.data))
+	}
+	if got, want := strings.Join(names, " "), "a_very_long_object_file_name.o=gnu short.o=short another_long_name.o=bsd"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestArchiveParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"not an archive", "missing !<arch> header"},
+		{arMagic + "short", "truncated member header"},
+		{arMagic + strings.Replace(arEntry("x.o", "x"), "`\n", "!\n", 1), "bad member header terminator"},
+		{arMagic + strings.Replace(arEntry("x.o", "x"), "1         `", "9         `", 1), "extends past end of archive"},
+		{arMagic + strings.Replace(arEntry("x.o", "x"), "644 ", "648 ", 1), `bad number "648"`},
+		{arMagic + arEntry("/5", "x"), "bad long name reference"},
+		{arMagic + arEntry("#1/9", "x"), "bad long name"},
+		{arMagic + arEntry("x.o", "x")[:61] + "!", "bad padding byte"},
+	}
+	for _, tt := range tests {
+		_, err := parseArchive([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseArchive(%q) = %v, want error containing %q", tt.data, err, tt.err)
+		}
+	}
+}
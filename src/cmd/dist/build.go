@@ -29,8 +29,7 @@ import (
 
 // Version prints the Go version.
 func cmdversion() {
-	xflagparse(0)
-	xprintf("%s\n", findgoversion())
+	_cmdversion()
 }
 
 // Banner prints the 'now you've installed Go' banner.
@@ -41,8 +40,7 @@ func cmdbanner() {
 
 // Clean deletes temporary objects.
 func cmdclean() {
-	xflagparse(0)
-	clean()
+	_cmdclean()
 }
 
 /*
@@ -54,10 +52,40 @@ func cmdenv() {
 	_cmdenv()
 }
 
+// Generate regenerates the generated source files.
+func cmdgenerate() {
+	_cmdgenerate()
+}
+
+// Distpack builds a distribution archive for another platform.
+func cmddistpack() {
+	_cmddistpack()
+}
+
+// Doctor checks the host environment before a build.
+func cmddoctor() {
+	_cmddoctor()
+}
+
+// Graph prints the dependency graph of the packages install would build.
+func cmdgraph() {
+	_cmdgraph()
+}
+
 func cmdlist() {
 	_cmdlist()
 }
 
+// Pack lists or extracts the members of a Go archive.
+func cmdpack() {
+	_cmdpack()
+}
+
+// Report summarizes a build event log.
+func cmdreport() {
+	_cmdreport()
+}
+
 func cmdtest() {
 	_cmdtest()
 }
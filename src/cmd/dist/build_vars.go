@@ -1,9 +1,7 @@
 package main
 
 import (
-	"os"
 	"sync"
-	"time"
 )
 
 // Initialization for any invocation.
@@ -91,89 +89,49 @@ var runtimegen = []string{
 }
 
 // gentab records how to generate some trivial files.
+// Install regenerates the files in a package whose names begin
+// with a nameprefix, and dist generate regenerates all the files
+// listed. The inputs of each generator are recorded so that
+// dist generate -v can explain the content it produces:
+// $X names an environment variable, and anything else
+// describes a value computed by dist.
 var gentab = []struct {
 	nameprefix string
-	gen        func(string, string)
+	gen        func(dir, file string) string // returns the content of file in package dir
+	files      []string                      // files generated, relative to $GOROOT/src
+	inputs     []string
 }{
-	{"zdefaultcc.go", mkzdefaultcc},
-	{"zosarch.go", mkzosarch},
-	{"zversion.go", mkzversion},
-	{"zcgo.go", mkzcgo},
+	{"zdefaultcc.go", mkzdefaultcc,
+		[]string{"cmd/go/internal/cfg/zdefaultcc.go", "cmd/cgo/zdefaultcc.go"},
+		[]string{"$CC", "$CC_FOR_${GOOS}_${GOARCH}", "$CXX", "$CXX_FOR_${GOOS}_${GOARCH}", "$PKG_CONFIG"}},
+	{"zosarch.go", mkzosarch,
+		[]string{"cmd/go/internal/cfg/zosarch.go"},
+		[]string{"platforms table"}},
+	{"zversion.go", mkzversion,
+		[]string{"runtime/internal/sys/zversion.go"},
+		[]string{"version", "$GOEXPERIMENT", "$GO_GCFLAGS"}},
+	{"zcgo.go", mkzcgo,
+		[]string{"go/build/zcgo.go"},
+		[]string{"platforms table", "$CGO_ENABLED"}},
+	{"zbootstrap.go", mkzbootstrap,
+		[]string{"cmd/internal/objabi/zbootstrap.go"},
+		[]string{"$GO386", "$GOARM", "$GOMIPS", "$GOMIPS64", "$GO_EXTLINK_ENABLED", "version", "$GOEXPERIMENT", "$GO_GCFLAGS"}},
 
 	// not generated anymore, but delete the file if we see it
-	{"enam.c", nil},
-	{"anames5.c", nil},
-	{"anames6.c", nil},
-	{"anames8.c", nil},
-	{"anames9.c", nil},
+	{"enam.c", nil, nil, nil},
+	{"anames5.c", nil, nil, nil},
+	{"anames6.c", nil, nil, nil},
+	{"anames8.c", nil, nil, nil},
+	{"anames9.c", nil, nil, nil},
 }
 
-// Cannot use go/build directly because cmd/dist for a new release
-// builds against an old release's go/build, which may be out of sync.
-// To reduce duplication, we generate the list for go/build from this.
-//
-// We list all supported platforms in this list, so that this is the
-// single point of truth for supported platforms. This list is used
-// by 'go tool dist list'.
-var cgoEnabled = map[string]bool{
-	"aix/ppc64":       false,
-	"darwin/386":      true,
-	"darwin/amd64":    true,
-	"darwin/arm":      true,
-	"darwin/arm64":    true,
-	"dragonfly/amd64": true,
-	"freebsd/386":     true,
-	"freebsd/amd64":   true,
-	"freebsd/arm":     false,
-	"linux/386":       true,
-	"linux/amd64":     true,
-	"linux/arm":       true,
-	"linux/arm64":     true,
-	"linux/ppc64":     false,
-	"linux/ppc64le":   true,
-	"linux/mips":      true,
-	"linux/mipsle":    true,
-	"linux/mips64":    true,
-	"linux/mips64le":  true,
-	"linux/riscv64":   true,
-	"linux/s390x":     true,
-	"linux/sparc64":   true,
-	"android/386":     true,
-	"android/amd64":   true,
-	"android/arm":     true,
-	"android/arm64":   true,
-	"js/wasm":         false,
-	"nacl/386":        false,
-	"nacl/amd64p32":   false,
-	"nacl/arm":        false,
-	"netbsd/386":      true,
-	"netbsd/amd64":    true,
-	"netbsd/arm":      true,
-	"openbsd/386":     true,
-	"openbsd/amd64":   true,
-	"openbsd/arm":     true,
-	"plan9/386":       false,
-	"plan9/amd64":     false,
-	"plan9/arm":       false,
-	"solaris/amd64":   true,
-	"windows/386":     true,
-	"windows/amd64":   true,
-	"windows/arm":     false,
-}
+// cgoEnabled records, for every supported platform, whether cgo
+// is supported there. See platforms for the full table.
+var cgoEnabled = platformMap(platCgo, true)
 
 // List of platforms which are supported but not complete yet. These get
 // filtered out of cgoEnabled for 'dist list'. See golang.org/issue/28944
-var incomplete = map[string]bool{
-	"linux/riscv64": true,
-	"linux/sparc64": true,
-}
-
-var (
-	timeLogEnabled = os.Getenv("GOBUILDTIMELOGFILE") != ""
-	timeLogMu      sync.Mutex
-	timeLogFile    *os.File
-	timeLogStart   time.Time
-)
+var incomplete = platformMap(platIncomplete, false)
 
 var toolchain = []string{"cmd/asm", "cmd/cgo", "cmd/compile", "cmd/link"}
 
@@ -182,6 +140,9 @@ var toolchain = []string{"cmd/asm", "cmd/cgo", "cmd/compile", "cmd/link"}
 var installed = make(map[string]chan struct{})
 var installedMu sync.Mutex
 
+// failedInstalls records the dirs whose install failed, with -k.
+var failedInstalls = make(map[string]bool)
+
 /*
  * Tool building
  */
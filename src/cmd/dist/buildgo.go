@@ -17,16 +17,16 @@ import (
  * Helpers for building cmd/go and cmd/cgo.
  */
 
-// mkzdefaultcc writes zdefaultcc.go:
+// mkzdefaultcc returns the content of zdefaultcc.go:
 //
 //  package main
 //  const defaultCC = <defaultcc>
 //  const defaultCXX = <defaultcxx>
 //  const defaultPkgConfig = <defaultpkgconfig>
 //
-// It is invoked to write cmd/go/internal/cfg/zdefaultcc.go
+// It is invoked for cmd/go/internal/cfg/zdefaultcc.go
 // but we also write cmd/cgo/zdefaultcc.go
-func mkzdefaultcc(dir, file string) {
+func mkzdefaultcc(dir, file string) string {
 	if strings.Contains(file, filepath.FromSlash("go/internal/cfg")) {
 		var buf bytes.Buffer
 		fmt.Fprintf(&buf, "// Code generated by go tool dist; DO NOT EDIT.\n")
@@ -36,8 +36,7 @@ func mkzdefaultcc(dir, file string) {
 		fmt.Fprintf(&buf, "const DefaultPkgConfig = `%s`\n", defaultpkgconfig)
 		buf.WriteString(defaultCCFunc("DefaultCC", defaultcc))
 		buf.WriteString(defaultCCFunc("DefaultCXX", defaultcxx))
-		writefile(buf.String(), file, writeSkipSame)
-		return
+		return buf.String()
 	}
 
 	var buf bytes.Buffer
@@ -48,7 +47,7 @@ func mkzdefaultcc(dir, file string) {
 	fmt.Fprintf(&buf, "const defaultPkgConfig = `%s`\n", defaultpkgconfig)
 	buf.WriteString(defaultCCFunc("defaultCC", defaultcc))
 	buf.WriteString(defaultCCFunc("defaultCXX", defaultcxx))
-	writefile(buf.String(), file, writeSkipSame)
+	return buf.String()
 }
 
 func defaultCCFunc(name string, defaultcc map[string]string) string {
@@ -73,8 +72,8 @@ func defaultCCFunc(name string, defaultcc map[string]string) string {
 	return buf.String()
 }
 
-// mkzcgo writes zosarch.go for cmd/go.
-func mkzosarch(dir, file string) {
+// mkzosarch returns the content of zosarch.go for cmd/go.
+func mkzosarch(dir, file string) string {
 	// sort for deterministic zosarch.go file
 	var list []string
 	for plat := range cgoEnabled {
@@ -91,16 +90,16 @@ func mkzosarch(dir, file string) {
 	}
 	fmt.Fprintf(&buf, "}\n")
 
-	writefile(buf.String(), file, writeSkipSame)
+	return buf.String()
 }
 
-// mkzcgo writes zcgo.go for the go/build package:
+// mkzcgo returns the content of zcgo.go for the go/build package:
 //
 //  package build
 //  var cgoEnabled = map[string]bool{}
 //
-// It is invoked to write go/build/zcgo.go.
-func mkzcgo(dir, file string) {
+// It is invoked for go/build/zcgo.go.
+func mkzcgo(dir, file string) string {
 	// sort for deterministic zcgo.go file
 	var list []string
 	for plat, hasCgo := range cgoEnabled {
@@ -123,5 +122,5 @@ func mkzcgo(dir, file string) {
 	}
 	fmt.Fprintf(&buf, "}\n")
 
-	writefile(buf.String(), file, writeSkipSame)
+	return buf.String()
 }
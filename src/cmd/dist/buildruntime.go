@@ -15,7 +15,7 @@ import (
  * Helpers for building runtime.
  */
 
-// mkzversion writes zversion.go:
+// mkzversion returns the content of zversion.go:
 //
 //  package sys
 //
@@ -23,7 +23,7 @@ import (
 //  const Goexperiment = <goexperiment>
 //  const StackGuardMultiplier = <multiplier value>
 //
-func mkzversion(dir, file string) {
+func mkzversion(dir, file string) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "// Code generated by go tool dist; DO NOT EDIT.\n")
 	fmt.Fprintln(&buf)
@@ -33,10 +33,10 @@ func mkzversion(dir, file string) {
 	fmt.Fprintf(&buf, "const Goexperiment = `%s`\n", os.Getenv("GOEXPERIMENT"))
 	fmt.Fprintf(&buf, "const StackGuardMultiplierDefault = %d\n", stackGuardMultiplierDefault())
 
-	writefile(buf.String(), file, writeSkipSame)
+	return buf.String()
 }
 
-// mkzbootstrap writes cmd/internal/objabi/zbootstrap.go:
+// mkzbootstrap returns the content of cmd/internal/objabi/zbootstrap.go:
 //
 //  package objabi
 //
@@ -61,7 +61,7 @@ func mkzversion(dir, file string) {
 // the resulting compiler will default to generating linux/ppc64 object files.
 // This is more useful than having it default to generating objects for the
 // original target (in this example, a Mac).
-func mkzbootstrap(file string) {
+func mkzbootstrap(dir, file string) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "// Code generated by go tool dist; DO NOT EDIT.\n")
 	fmt.Fprintln(&buf)
@@ -80,7 +80,7 @@ func mkzbootstrap(file string) {
 	fmt.Fprintf(&buf, "const stackGuardMultiplierDefault = %d\n", stackGuardMultiplierDefault())
 	fmt.Fprintf(&buf, "const goexperiment = `%s`\n", os.Getenv("GOEXPERIMENT"))
 
-	writefile(buf.String(), file, writeSkipSame)
+	return buf.String()
 }
 
 // stackGuardMultiplierDefault returns a multiplier to apply to the default
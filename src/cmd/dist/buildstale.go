@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Content-based staleness for targets built by runInstall.
+ *
+ * Each target has a manifest recording a hash of every input
+ * that went into it: the source files, the build configuration,
+ * the tool binaries and the manifests of its dependencies.
+ * The last line of the manifest is the hash of the target itself.
+ * Since each manifest holds the hashes of its dependencies'
+ * manifests, it changes whenever anything in the transitive
+ * closure does, even if a dependency's target comes out the
+ * same. That matters for commands: the linker reads the whole
+ * closure from $GOROOT/pkg, not just the direct imports.
+ * A target is up to date when it still has that hash and
+ * the manifest computed from the current inputs is unchanged.
+ * Unlike modification times, this survives git checkouts,
+ * timestamp-preserving copies and branch switches.
+ */
+
+var (
+	hashMu       sync.Mutex
+	toolHashes   = map[string]string{}
+	targetHashes = map[string]string{} // dir -> hash of the manifest of its installed target
+)
+
+// hashFile returns the SHA-256 hash of the content of file p,
+// or the empty string if p cannot be read.
+func hashFile(p string) string {
+	f, err := os.Open(p)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// hashString returns the SHA-256 hash of s.
+func hashString(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// toolHash returns the hash of the tool binary name in tooldir.
+// Tools do not change while dist runs, so the result is cached.
+func toolHash(name string) string {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	h, ok := toolHashes[name]
+	if !ok {
+		h = hashFile(pathf("%s/%s%s", tooldir, name, exe))
+		toolHashes[name] = h
+	}
+	return h
+}
+
+// setTargetHash records h as the hash of the manifest,
+// including the target line, of the installed target for dir.
+func setTargetHash(dir, h string) {
+	hashMu.Lock()
+	targetHashes[dir] = h
+	hashMu.Unlock()
+}
+
+// installedHash returns the hash of the manifest of the target
+// installed for dir, which identifies the target and everything
+// it was built from. It must only be called after install(dir)
+// has returned.
+func installedHash(dir string) string {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	return targetHashes[dir]
+}
+
+// manifestFile returns the name of the manifest for the target built from dir.
+func manifestFile(dir string) string {
+	return pathf("%s/pkg/obj/dist/%s_%s/%s.manifest", goroot, goos, goarch, dir)
+}
+
+// buildManifest returns the manifest describing the inputs
+// used to build dir from files, with the given dependencies
+// and tools. Paths are recorded relative to $GOROOT so that
+// the manifest does not change when the tree is moved.
+func buildManifest(dir string, files, deps, tools []string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "dir %s\n", dir)
+	for _, kv := range [][2]string{
+		{"GOOS", goos},
+		{"GOARCH", goarch},
+		{"GOARM", goarm},
+		{"GO386", go386},
+		{"GOMIPS", gomips},
+		{"GOMIPS64", gomips64},
+		{"gcflags", gogcflags},
+		{"cgo", strconv.FormatBool(cgoTag())},
+		{"tags", buildTagList()},
+	} {
+		fmt.Fprintf(&buf, "env %s=%q\n", kv[0], kv[1])
+	}
+	for _, name := range tools {
+		fmt.Fprintf(&buf, "tool %s %s\n", name, toolHash(name))
+	}
+	for _, p := range files {
+		rel := p
+		if r, err := filepath.Rel(goroot, p); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+		fmt.Fprintf(&buf, "file %s %s\n", rel, hashFile(p))
+	}
+	for _, dep := range deps {
+		fmt.Fprintf(&buf, "dep %s %s\n", dep, installedHash(dep))
+	}
+	return buf.String()
+}
+
+// isStale reports whether the target built from dir needs to be rebuilt
+// given its current manifest m. If so, it also returns a short reason.
+// If not, it records the target's hash for use by its dependents.
+func isStale(dir, target, m string) (bool, string) {
+	if rebuildall {
+		return true, "rebuilding all"
+	}
+	h := hashFile(target)
+	if h == "" {
+		return true, "target missing"
+	}
+	old, err := readManifest(dir)
+	if err != nil {
+		return true, "no manifest"
+	}
+	if old != m+"target "+h+"\n" {
+		return true, "inputs changed: " + manifestDiff(old, m+"target "+h+"\n")
+	}
+	setTargetHash(dir, hashString(old))
+	return false, ""
+}
+
+// readManifest returns the manifest recorded for dir.
+func readManifest(dir string) (string, error) {
+	data, err := ioutil.ReadFile(manifestFile(dir))
+	return string(data), err
+}
+
+// writeManifest records m as the manifest of the freshly built target for dir.
+func writeManifest(dir, target, m string) {
+	h := hashFile(target)
+	if h == "" {
+		fatalf("cannot hash %s after building it", target)
+	}
+	m += "target " + h + "\n"
+	setTargetHash(dir, hashString(m))
+	file := manifestFile(dir)
+	xmkdirall(filepath.Dir(file))
+	writefile(m, file, writeSkipSame)
+}
+
+// manifestDiff returns the first line of new that is not in old,
+// which names the input that made a target stale.
+func manifestDiff(old, new string) string {
+	have := map[string]bool{}
+	for _, line := range strings.Split(old, "\n") {
+		have[line] = true
+	}
+	for _, line := range strings.Split(new, "\n") {
+		if !have[line] {
+			if i := strings.LastIndex(line, " "); i > 0 && !strings.HasPrefix(line, "env ") {
+				line = line[:i]
+			}
+			return line
+		}
+	}
+	return "input removed"
+}
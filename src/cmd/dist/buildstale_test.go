@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestManifestTransitive checks that a change deep in the
+// dependencies of a command makes the command stale, even when
+// the archives of its direct dependencies come out the same.
+func TestManifestTransitive(t *testing.T) {
+	root := tempGoroot(t, map[string]string{
+		"src/a/a.go":        "package a\n\nfunc f() int { return 1 }\n",
+		"src/b/b.go":        "package b\n\nimport _ \"a\"\n",
+		"src/cmd/c/main.go": "package main\n\nimport _ \"b\"\n",
+		"pkg/a.a":           "archive a",
+		"pkg/b.a":           "archive b",
+		"pkg/c":             "binary c",
+	})
+	defer os.RemoveAll(root)
+	defer func(old map[string]string) { targetHashes = old }(targetHashes)
+	defer func(old bool) { rebuildall = old }(rebuildall)
+	rebuildall = false
+
+	withBuild(t, "linux", "amd64", "", root, func() {
+		// install builds dir, if stale, and returns its manifest.
+		install := func(dir, file, target string, deps ...string) string {
+			m := buildManifest(dir, []string{pathf("%s/src/%s/%s", root, dir, file)}, deps, nil)
+			if stale, _ := isStale(dir, pathf("%s/%s", root, target), m); stale {
+				writeManifest(dir, pathf("%s/%s", root, target), m)
+			}
+			return m
+		}
+		targetHashes = map[string]string{}
+		install("a", "a.go", "pkg/a.a")
+		install("b", "b.go", "pkg/b.a", "a")
+		before := install("cmd/c", "main.go", "pkg/c", "b")
+
+		// Nothing changed: c is up to date.
+		targetHashes = map[string]string{}
+		install("a", "a.go", "pkg/a.a")
+		install("b", "b.go", "pkg/b.a", "a")
+		m := buildManifest("cmd/c", []string{pathf("%s/src/cmd/c/main.go", root)}, []string{"b"}, nil)
+		if stale, why := isStale("cmd/c", pathf("%s/pkg/c", root), m); stale {
+			t.Fatalf("cmd/c stale with nothing changed: %s", why)
+		}
+
+		// Change a function body in a without changing either archive.
+		writefile("package a\n\nfunc f() int { return 2 }\n", pathf("%s/src/a/a.go", root), 0)
+		targetHashes = map[string]string{}
+		install("a", "a.go", "pkg/a.a")
+		install("b", "b.go", "pkg/b.a", "a")
+		after := buildManifest("cmd/c", []string{pathf("%s/src/cmd/c/main.go", root)}, []string{"b"}, nil)
+		if after == before {
+			t.Fatalf("manifest of cmd/c unchanged after a change in a:\n%s", after)
+		}
+		if stale, _ := isStale("cmd/c", pathf("%s/pkg/c", root), after); !stale {
+			t.Errorf("cmd/c not stale after a change in a")
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Build constraints.
+ *
+ * These follow the rules of package go/build, which cmd/dist cannot
+ * import, for both // +build lines and //go:build expressions.
+ * A file's constraints are evaluated against goos and goarch,
+ * the compiler tag gc, the cgo tag, the release tags go1.1 through
+ * the version being built, the architecture feature tags like
+ * amd64.v1 or arm.7, the tags given with -tags, and cmd_go_bootstrap,
+ * since the only go command that dist builds is go_bootstrap.
+ */
+
+// buildTags holds the tags given with install's -tags flag.
+var buildTags = map[string]bool{}
+
+// setBuildTags records the tags in list, which may be
+// separated by commas or spaces, as given with -tags.
+func setBuildTags(list string) {
+	for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		buildTags[tag] = true
+	}
+}
+
+// buildTagList returns the tags given with -tags, sorted and comma-separated.
+func buildTagList() string {
+	var list []string
+	for tag := range buildTags {
+		list = append(list, tag)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// cgoTag reports whether the cgo tag is satisfied. dist cannot build
+// files that use cgo, so, as for go_bootstrap, cgo is off unless it is
+// asked for with -tags cgo on a platform that supports it.
+func cgoTag() bool {
+	return buildTags["cgo"] && cgoEnabled[goos+"/"+goarch]
+}
+
+var (
+	releaseOnce  sync.Once
+	releaseMinor int
+)
+
+var (
+	goversionRE = regexp.MustCompile(`(?m)^const Version = ([0-9]+)$`)
+	versionRE   = regexp.MustCompile(`^go1\.([0-9]+)`)
+)
+
+// releaseTag reports whether tag is a release tag go1.N
+// satisfied by the version being built.
+func releaseTag(tag string) bool {
+	if !strings.HasPrefix(tag, "go1.") {
+		return false
+	}
+	n, err := strconv.Atoi(tag[len("go1."):])
+	if err != nil || n < 1 {
+		return false
+	}
+	releaseOnce.Do(func() {
+		// The version being built is recorded in internal/goversion,
+		// or failing that in the VERSION file of a release.
+		// Always allow go1.1, as dist did before it knew the version.
+		releaseMinor = 1
+		if file := pathf("%s/src/internal/goversion/goversion.go", goroot); isfile(file) {
+			if m := goversionRE.FindStringSubmatch(readfile(file)); m != nil {
+				releaseMinor, _ = strconv.Atoi(m[1])
+			}
+		} else if file := pathf("%s/VERSION", goroot); isfile(file) {
+			if m := versionRE.FindStringSubmatch(readfile(file)); m != nil {
+				releaseMinor, _ = strconv.Atoi(m[1])
+			}
+		}
+	})
+	return n <= releaseMinor
+}
+
+// featureTag reports whether tag is an architecture feature tag
+// like amd64.v2 or arm.6 satisfied by the settings for goarch.
+func featureTag(tag string) bool {
+	i := strings.Index(tag, ".")
+	if i < 0 || tag[:i] != goarch {
+		return false
+	}
+	feature := tag[i+1:]
+	switch goarch {
+	case "386":
+		return feature == go386
+	case "amd64":
+		level := strings.TrimPrefix(os.Getenv("GOAMD64"), "v")
+		return strings.HasPrefix(feature, "v") && atoiOr(feature[1:], 99) <= atoiOr(level, 1)
+	case "arm":
+		return atoiOr(feature, 99) <= atoiOr(goarm, 5)
+	case "mips", "mipsle":
+		return feature == gomips
+	case "mips64", "mips64le":
+		return feature == gomips64
+	}
+	return false
+}
+
+// atoiOr returns the value of the decimal number s, or def if s is not one.
+func atoiOr(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// matchfield reports whether the field (x,y,z) matches this build.
+// all the elements in the field must be satisfied.
+func matchfield(f string) bool {
+	for _, tag := range strings.Split(f, ",") {
+		if !matchtag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchtag reports whether the tag (x or !x) matches this build.
+func matchtag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	if tag[0] == '!' {
+		if len(tag) == 1 || tag[1] == '!' {
+			return false
+		}
+		return !matchtag(tag[1:])
+	}
+	switch tag {
+	case "gc", "cmd_go_bootstrap", goos, goarch:
+		return true
+	case "linux":
+		return goos == "android"
+	case "cgo":
+		return cgoTag()
+	}
+	return buildTags[tag] || releaseTag(tag) || featureTag(tag)
+}
+
+// constraintReason evaluates the build constraints in the leading
+// comments of a file. It returns the empty string if they are
+// satisfied, and otherwise the constraint that is not, like
+// "+build !linux" or "//go:build cgo && !windows".
+//
+// As in go/build, only constraints in the comments that are
+// followed by a blank line count, and a //go:build line takes
+// precedence over any // +build lines.
+func constraintReason(file, comments string) string {
+	if i := strings.LastIndex(comments, "\n\n"); i >= 0 {
+		comments = comments[:i]
+	} else {
+		comments = ""
+	}
+	var plusBuild []string
+	for _, line := range strings.Split(comments, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//go:build ") {
+			expr := strings.TrimSpace(line[len("//go:build "):])
+			ok, err := evalGoBuild(expr)
+			if err != nil {
+				fatalf("%s: invalid //go:build line: %v", file, err)
+			}
+			if !ok {
+				return "//go:build " + expr
+			}
+			return ""
+		}
+		if !strings.HasPrefix(line, "//") {
+			continue
+		}
+		fields := strings.Fields(line[2:])
+		if len(fields) > 0 && fields[0] == "+build" {
+			plusBuild = append(plusBuild, line)
+		}
+	}
+	for _, line := range plusBuild {
+		fields := strings.Fields(line[2:])
+		ok := false
+		for _, f := range fields[1:] {
+			if matchfield(f) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return strings.Join(fields, " ")
+		}
+	}
+	return ""
+}
+
+// evalGoBuild evaluates the expression of a //go:build line.
+func evalGoBuild(expr string) (bool, error) {
+	p := &exprParser{s: expr}
+	ok := p.or()
+	if p.err == nil && p.tok() != "" {
+		p.err = fmt.Errorf("unexpected %q", p.tok())
+	}
+	return ok, p.err
+}
+
+// An exprParser parses and evaluates a //go:build expression
+// by recursive descent, with the usual precedence of ||, && and !.
+type exprParser struct {
+	s   string // remaining input
+	err error
+}
+
+// tok returns the next token without consuming it:
+// one of ! && || ( ), a tag, or "" at the end of the input.
+func (p *exprParser) tok() string {
+	p.s = strings.TrimLeft(p.s, " \t")
+	if p.s == "" {
+		return ""
+	}
+	if strings.HasPrefix(p.s, "&&") || strings.HasPrefix(p.s, "||") {
+		return p.s[:2]
+	}
+	switch p.s[0] {
+	case '!', '(', ')':
+		return p.s[:1]
+	}
+	i := 0
+	for i < len(p.s) && isTagByte(p.s[i]) {
+		i++
+	}
+	if i == 0 {
+		if p.err == nil {
+			p.err = fmt.Errorf("unexpected %q", p.s[:1])
+		}
+		p.s = ""
+		return ""
+	}
+	return p.s[:i]
+}
+
+func isTagByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '.'
+}
+
+// next consumes the token returned by tok.
+func (p *exprParser) next() {
+	p.s = p.s[len(p.tok()):]
+}
+
+func (p *exprParser) or() bool {
+	x := p.and()
+	for p.tok() == "||" {
+		p.next()
+		y := p.and()
+		x = x || y
+	}
+	return x
+}
+
+func (p *exprParser) and() bool {
+	x := p.not()
+	for p.tok() == "&&" {
+		p.next()
+		y := p.not()
+		x = x && y
+	}
+	return x
+}
+
+func (p *exprParser) not() bool {
+	switch t := p.tok(); t {
+	case "!":
+		p.next()
+		if p.tok() == "!" && p.err == nil {
+			p.err = fmt.Errorf("double negation not allowed")
+		}
+		return !p.not()
+	case "(":
+		p.next()
+		x := p.or()
+		if p.tok() != ")" {
+			if p.err == nil {
+				p.err = fmt.Errorf("missing )")
+			}
+			return false
+		}
+		p.next()
+		return x
+	case "", "&&", "||", ")":
+		if p.err == nil {
+			p.err = fmt.Errorf("missing tag")
+		}
+		return false
+	default:
+		p.next()
+		return matchtag(t)
+	}
+}
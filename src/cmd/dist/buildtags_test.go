@@ -0,0 +1,246 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// withBuild runs f with the build settings set for goos/goarch,
+// the given -tags, and a $GOROOT at root, restoring them afterward.
+func withBuild(t *testing.T, goos1, goarch1, tags, root string, f func()) {
+	oldGoos, oldGoarch, oldGoroot := goos, goarch, goroot
+	oldGoarm, oldGo386, oldTags := goarm, go386, buildTags
+	defer func() {
+		goos, goarch, goroot = oldGoos, oldGoarch, oldGoroot
+		goarm, go386, buildTags = oldGoarm, oldGo386, oldTags
+		releaseOnce = sync.Once{}
+	}()
+	goos, goarch, goroot = goos1, goarch1, root
+	goarm, go386 = "7", "sse2"
+	buildTags = map[string]bool{}
+	setBuildTags(tags)
+	releaseOnce = sync.Once{}
+	f()
+}
+
+// tempGoroot returns a temporary $GOROOT holding the named files.
+func tempGoroot(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "dist-buildtags-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var goBuildTests = []struct {
+	expr string
+	want bool
+	err  string
+}{
+	{expr: "linux", want: true},
+	{expr: "!linux", want: false},
+	{expr: "linux && amd64", want: true},
+	{expr: "linux && arm", want: false},
+	{expr: "windows || amd64", want: true},
+	{expr: "!windows && !darwin", want: true},
+
+	// && binds tighter than ||, and ! tighter than both.
+	{expr: "windows && arm || linux", want: true},
+	{expr: "linux || windows && arm", want: true},
+	{expr: "windows && (arm || linux)", want: false},
+	{expr: "(linux || windows) && arm", want: false},
+	{expr: "!windows && linux || arm", want: true},
+	{expr: "!(windows || linux)", want: false},
+	{expr: "!(linux && windows)", want: true},
+	{expr: "((linux))", want: true},
+
+	// Tags from -tags, release tags and the cgo tag.
+	{expr: "mytag && !othertag", want: true},
+	{expr: "go1.1 && go1.12", want: true},
+	{expr: "go1.13", want: false},
+	{expr: "cgo", want: false},
+	{expr: "gc && cmd_go_bootstrap", want: true},
+
+	// Malformed expressions.
+	{expr: "(linux", err: "missing )"},
+	{expr: "linux)", err: `unexpected ")"`},
+	{expr: "!!linux", err: "double negation not allowed"},
+	{expr: "linux &&", err: "missing tag"},
+	{expr: "|| linux", err: "missing tag"},
+	{expr: "linux amd64", err: `unexpected "amd64"`},
+	{expr: "linux & amd64", err: `unexpected "&"`},
+	{expr: "", err: "missing tag"},
+}
+
+func TestEvalGoBuild(t *testing.T) {
+	root := tempGoroot(t, map[string]string{
+		"src/internal/goversion/goversion.go": "package goversion\n\nconst Version = 12\n",
+	})
+	defer os.RemoveAll(root)
+	withBuild(t, "linux", "amd64", "mytag", root, func() {
+		for _, tt := range goBuildTests {
+			got, err := evalGoBuild(tt.expr)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("evalGoBuild(%q) = %v, %v, want error %q", tt.expr, got, err, tt.err)
+				}
+				continue
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("evalGoBuild(%q) = %v, %v, want %v", tt.expr, got, err, tt.want)
+			}
+		}
+	})
+}
+
+var constraintTests = []struct {
+	comments string
+	want     string
+}{
+	{"// +build linux\n\n", ""},
+	{"// +build windows\n\n", "+build windows"},
+	{"// +build windows linux\n\n", ""},
+	{"// +build linux,!amd64\n\n", "+build linux,!amd64"},
+	{"// +build linux\n// +build arm\n\n", "+build arm"},
+	{"// +build !!linux\n\n", "+build !!linux"},
+
+	// //go:build takes precedence over // +build lines.
+	{"//go:build linux\n// +build windows\n\n", ""},
+	{"// +build linux\n//go:build windows\n\n", "//go:build windows"},
+	{"//go:build linux && !cgo\n// +build linux,cgo\n\n", ""},
+
+	// Only the comments followed by a blank line count.
+	{"// +build windows\n", ""},
+	{"//go:build windows\n", ""},
+	{"// +build windows\n\n// Package p does things.\n", "+build windows"},
+	{"// Copyright\n\n// +build windows\n", ""},
+	{"// Copyright\n\n// +build windows\n\n", "+build windows"},
+
+	// Lines that only look like constraints.
+	{"// +buildwindows\n\n", ""},
+	{"// a +build windows\n\n", ""},
+	{"/* +build windows */\n\n", ""},
+}
+
+func TestConstraintReason(t *testing.T) {
+	withBuild(t, "linux", "amd64", "", "", func() {
+		for _, tt := range constraintTests {
+			if got := constraintReason("x.go", tt.comments); got != tt.want {
+				t.Errorf("constraintReason(%q) = %q, want %q", tt.comments, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestReleaseTags(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		tag   string
+		want  bool
+	}{
+		{map[string]string{"src/internal/goversion/goversion.go": "package goversion\n\nconst Version = 12\n"}, "go1.12", true},
+		{map[string]string{"src/internal/goversion/goversion.go": "package goversion\n\nconst Version = 12\n"}, "go1.13", false},
+
+		// goversion.go is preferred to VERSION.
+		{map[string]string{
+			"src/internal/goversion/goversion.go": "package goversion\n\nconst Version = 12\n",
+			"VERSION":                             "go1.20",
+		}, "go1.13", false},
+
+		// A release has only VERSION.
+		{map[string]string{"VERSION": "go1.10.3"}, "go1.10", true},
+		{map[string]string{"VERSION": "go1.10.3"}, "go1.11", false},
+		{map[string]string{"VERSION": "devel +abc"}, "go1.2", false},
+
+		// go1.1 is always satisfied.
+		{map[string]string{}, "go1.1", true},
+		{map[string]string{}, "go1.2", false},
+
+		// Not release tags.
+		{map[string]string{"VERSION": "go1.10"}, "go1.0", false},
+		{map[string]string{"VERSION": "go1.10"}, "go1.x", false},
+		{map[string]string{"VERSION": "go1.10"}, "go2.1", false},
+	}
+	for _, tt := range tests {
+		root := tempGoroot(t, tt.files)
+		withBuild(t, "linux", "amd64", "", root, func() {
+			if got := matchtag(tt.tag); got != tt.want {
+				t.Errorf("with %v: matchtag(%q) = %v, want %v", tt.files, tt.tag, got, tt.want)
+			}
+		})
+		os.RemoveAll(root)
+	}
+}
+
+func TestFeatureTags(t *testing.T) {
+	defer os.Setenv("GOAMD64", os.Getenv("GOAMD64"))
+	tests := []struct {
+		goarch  string
+		goarm   string
+		goamd64 string
+		tag     string
+		want    bool
+	}{
+		{"arm", "7", "", "arm.5", true},
+		{"arm", "7", "", "arm.7", true},
+		{"arm", "6", "", "arm.7", false},
+		{"arm", "", "", "arm.5", true},
+		{"arm", "", "", "arm.6", false},
+		{"arm", "7", "", "arm.x", false},
+		{"amd64", "", "", "amd64.v1", true},
+		{"amd64", "", "", "amd64.v2", false},
+		{"amd64", "", "v3", "amd64.v2", true},
+		{"amd64", "", "v3", "amd64.v3", true},
+		{"amd64", "", "v3", "amd64.v4", false},
+		{"amd64", "", "v3", "amd64.3", false},
+		{"amd64", "", "v3", "arm.5", false},
+		{"arm", "7", "", "amd64.v1", false},
+	}
+	for _, tt := range tests {
+		os.Setenv("GOAMD64", tt.goamd64)
+		withBuild(t, "linux", tt.goarch, "", "", func() {
+			goarm = tt.goarm
+			if got := matchtag(tt.tag); got != tt.want {
+				t.Errorf("GOARCH=%s GOARM=%s GOAMD64=%s: matchtag(%q) = %v, want %v", tt.goarch, tt.goarm, tt.goamd64, tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCgoTag(t *testing.T) {
+	defer os.Setenv("CGO_ENABLED", os.Getenv("CGO_ENABLED"))
+	os.Setenv("CGO_ENABLED", "1")
+	tests := []struct {
+		goos, goarch, tags string
+		want               bool
+	}{
+		// cgo is off by default, as for go_bootstrap, whatever $CGO_ENABLED says.
+		{"linux", "amd64", "", false},
+		{"linux", "amd64", "cgo", true},
+		{"linux", "amd64", "x,cgo", true},
+		// -tags cgo does not enable it where cgo is not supported.
+		{"nacl", "amd64p32", "cgo", false},
+	}
+	for _, tt := range tests {
+		withBuild(t, tt.goos, tt.goarch, tt.tags, "", func() {
+			if got := matchtag("cgo"); got != tt.want {
+				t.Errorf("%s/%s -tags=%q: matchtag(cgo) = %v, want %v", tt.goos, tt.goarch, tt.tags, got, tt.want)
+			}
+			if got := matchtag("!cgo"); got == tt.want {
+				t.Errorf("%s/%s -tags=%q: matchtag(!cgo) = %v, want %v", tt.goos, tt.goarch, tt.tags, got, !tt.want)
+			}
+		})
+	}
+}
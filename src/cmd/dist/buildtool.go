@@ -12,10 +12,15 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -111,34 +116,49 @@ var ignoreSuffixes = []string{
 	"_wasm.go",
 }
 
-func bootstrapBuildTools() {
-	goroot_bootstrap := os.Getenv("GOROOT_BOOTSTRAP")
-	if goroot_bootstrap == "" {
-		goroot_bootstrap = pathf("%s/go1.4", os.Getenv("HOME"))
+// bootstrapRoot returns the GOROOT of the Go toolchain
+// used to build toolchain1.
+func bootstrapRoot() string {
+	if b := os.Getenv("GOROOT_BOOTSTRAP"); b != "" {
+		return b
 	}
+	return pathf("%s/go1.4", os.Getenv("HOME"))
+}
+
+func bootstrapBuildTools() {
+	goroot_bootstrap := bootstrapRoot()
 	xprintf("Building Go toolchain1 using %s.\n", goroot_bootstrap)
 
-	mkzbootstrap(pathf("%s/src/cmd/internal/objabi/zbootstrap.go", goroot))
+	generate("cmd/internal/objabi", pathf("%s/src/cmd/internal/objabi/zbootstrap.go", goroot))
 
 	// Use $GOROOT/pkg/bootstrap as the bootstrap workspace root.
 	// We use a subdirectory of $GOROOT/pkg because that's the
 	// space within $GOROOT where we store all generated objects.
 	// We could use a temporary directory outside $GOROOT instead,
 	// but it is easier to debug on failure if the files are in a known location.
+	//
+	// The workspace is kept from one run to the next (clean removes
+	// it when rebuilding all), so that the bootstrap go command can
+	// reuse the packages it built last time. To let it, a rewritten
+	// file is only written when its content changes.
 	workspace := pathf("%s/pkg/bootstrap", goroot)
-	xremoveall(workspace)
 	base := pathf("%s/src/bootstrap", workspace)
 	xmkdirall(base)
 
 	// Copy source code into $GOROOT/pkg/bootstrap and rewrite import paths.
+	keep := map[string]bool{base: true}
+	var problems []string
 	for _, dir := range bootstrapDirs {
 		src := pathf("%s/src/%s", goroot, dir)
 		dst := pathf("%s/%s", base, dir)
 		xmkdirall(dst)
+		for d := dst; d != base; d = filepath.Dir(d) {
+			keep[d] = true
+		}
 		if dir == "cmd/cgo" {
 			// Write to src because we need the file both for bootstrap
 			// and for later in the main build.
-			mkzdefaultcc("", pathf("%s/zdefaultcc.go", src))
+			generate(dir, pathf("%s/zdefaultcc.go", src))
 		}
 	Dir:
 		for _, name := range xreaddirfiles(src) {
@@ -154,10 +174,20 @@ func bootstrapBuildTools() {
 			}
 			srcFile := pathf("%s/%s", src, name)
 			dstFile := pathf("%s/%s", dst, name)
-			text := bootstrapRewriteFile(srcFile)
-			writefile(text, dstFile, 0)
+			keep[dstFile] = true
+			text, fileProblems := bootstrapRewriteFile(srcFile)
+			if len(fileProblems) > 0 {
+				problems = append(problems, fileProblems...)
+				xremove(dstFile)
+				continue
+			}
+			writefile(text, dstFile, writeSkipSame)
 		}
 	}
+	if len(problems) > 0 {
+		fatalf("bootstrap copy cannot be built:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	pruneBootstrap(base, keep)
 
 	// Set up environment for invoking Go 1.4 go command.
 	// GOROOT points at Go 1.4 GOROOT,
@@ -191,7 +221,6 @@ func bootstrapBuildTools() {
 	// Note that if we are using Go 1.10 or later as bootstrap, the -gcflags=-l
 	// only applies to the final cmd/go binary, but that's OK: if this is Go 1.10
 	// or later we don't need to disable inlining to work around bugs in the Go 1.4 compiler.
-	_p(1, "ENV=", os.Environ())
 	cmd := []string{
 		pathf("%s/bin/go", goroot_bootstrap),
 		"install",
@@ -223,6 +252,30 @@ func bootstrapBuildTools() {
 	}
 }
 
+// pruneBootstrap removes from the bootstrap source tree base the
+// files and directories that are not in keep: those left behind
+// by an earlier run for sources that have since been deleted,
+// or for directories that are no longer in bootstrapDirs.
+func pruneBootstrap(base string, keep map[string]bool) {
+	var remove []string
+	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil || keep[path] {
+			return nil
+		}
+		remove = append(remove, path)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	for _, path := range remove {
+		if vflag > 1 {
+			errprintf("rm %s (no longer bootstrapped)\n", path)
+		}
+		xremoveall(path)
+	}
+}
+
 var ssaRewriteFileSubstring = filepath.FromSlash("src/cmd/compile/internal/ssa/rewrite")
 
 // isUnneededSSARewriteFile reports whether srcFile is a
@@ -254,7 +307,7 @@ func isUnneededSSARewriteFile(srcFile string) (archCaps string, unneeded bool) {
 	return archCaps, true
 }
 
-func bootstrapRewriteFile(srcFile string) string {
+func bootstrapRewriteFile(srcFile string) (string, []string) {
 	// During bootstrap, generate dummy rewrite files for
 	// irrelevant architectures. We only need to build a bootstrap
 	// binary that works for the current runtime.GOARCH.
@@ -266,38 +319,104 @@ package ssa
 
 func rewriteValue%s(v *Value) bool { panic("unused during bootstrap") }
 func rewriteBlock%s(b *Block) bool { panic("unused during bootstrap") }
-`, archCaps, archCaps)
+`, archCaps, archCaps), nil
 	}
 
 	return bootstrapFixImports(srcFile)
 }
 
-func bootstrapFixImports(srcFile string) string {
-	lines := strings.SplitAfter(readfile(srcFile), "\n")
-	inBlock := false
-	for i, line := range lines {
-		if strings.HasPrefix(line, "import (") {
-			inBlock = true
-			continue
+// bootstrapFixImports returns the content of srcFile with every import
+// of a package in cmd/ or bootstrapDirs redirected to its bootstrap/ copy.
+// Only the import paths change, so comments and //line directives
+// survive as they are. It also returns a description of each import
+// that the bootstrap toolchain would not be able to satisfy.
+func bootstrapFixImports(srcFile string) (string, []string) {
+	text := readfile(srcFile)
+	header := "// Code generated by go tool dist; DO NOT EDIT.\n// This is a bootstrap copy of " + srcFile + "\n\n//line " + srcFile + ":1\n"
+	if !strings.HasSuffix(srcFile, ".go") {
+		return header + text, nil
+	}
+
+	// Problems only matter in files that the bootstrap
+	// toolchain is actually going to compile.
+	check := bootstrapCompiles(srcFile)
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, srcFile, text, parser.ImportsOnly)
+	if err != nil {
+		if check {
+			return "", []string{err.Error()}
 		}
-		if inBlock && strings.HasPrefix(line, ")") {
-			inBlock = false
-			continue
+		return header + text, nil
+	}
+
+	var problems []string
+	var buf bytes.Buffer
+	last := 0
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue // reported by the parser already
 		}
-		if strings.HasPrefix(line, `import "`) || strings.HasPrefix(line, `import . "`) ||
-			inBlock && (strings.HasPrefix(line, "\t\"") || strings.HasPrefix(line, "\t. \"")) {
-			line = strings.Replace(line, `"cmd/`, `"bootstrap/cmd/`, -1)
-			for _, dir := range bootstrapDirs {
-				if strings.HasPrefix(dir, "cmd/") {
-					continue
-				}
-				line = strings.Replace(line, `"`+dir+`"`, `"bootstrap/`+dir+`"`, -1)
-			}
-			lines[i] = line
+		newPath, problem := bootstrapImport(path)
+		start := fset.Position(spec.Path.Pos()).Offset
+		end := fset.Position(spec.Path.End()).Offset
+		if problem != "" && check {
+			// Report the line in srcFile itself, not the one
+			// that a //line directive may say it came from.
+			line := strings.Count(text[:start], "\n") + 1
+			problems = append(problems, fmt.Sprintf("%s:%d: import %q: %s", srcFile, line, path, problem))
 		}
+		if newPath == path {
+			continue
+		}
+		buf.WriteString(text[last:start])
+		buf.WriteString(strconv.Quote(newPath))
+		last = end
 	}
+	buf.WriteString(text[last:])
 
-	lines[0] = "// Code generated by go tool dist; DO NOT EDIT.\n// This is a bootstrap copy of " + srcFile + "\n\n//line " + srcFile + ":1\n" + lines[0]
+	return header + buf.String(), problems
+}
 
-	return strings.Join(lines, "")
+// bootstrapImport returns the path under which the bootstrap copy of a
+// file must import path. If the bootstrap toolchain has no package
+// for that path, it also returns a description of the problem.
+func bootstrapImport(path string) (newPath, problem string) {
+	for _, dir := range bootstrapDirs {
+		if path == dir {
+			return "bootstrap/" + path, ""
+		}
+	}
+	if strings.HasPrefix(path, "cmd/") {
+		return "bootstrap/" + path, "not in bootstrapDirs"
+	}
+	if path == "C" || path == "unsafe" {
+		return path, ""
+	}
+	if path == "internal" || strings.HasPrefix(path, "internal/") || strings.Contains(path, "/internal/") || strings.HasSuffix(path, "/internal") {
+		return path, "use of internal package of the bootstrap toolchain not allowed"
+	}
+	if !isdir(pathf("%s/src/%s", bootstrapRoot(), path)) {
+		return path, "not in the bootstrap toolchain " + bootstrapRoot()
+	}
+	return path, ""
+}
+
+// bootstrapCompiles reports whether the bootstrap toolchain
+// will compile the file when building the bootstrap copy of
+// its package: whether it is not a test and its name and
+// build constraints match the bootstrap build.
+func bootstrapCompiles(file string) bool {
+	if strings.HasSuffix(file, "_test.go") {
+		return false
+	}
+	ctxt := build.Default
+	ctxt.GOROOT = bootstrapRoot()
+	ctxt.GOPATH = ""
+	ctxt.GOOS = runtime.GOOS
+	ctxt.GOARCH = runtime.GOARCH
+	ctxt.BuildTags = []string{"math_big_pure_go", "compiler_bootstrap"}
+	ok, err := ctxt.MatchFile(filepath.Dir(file), filepath.Base(file))
+	return ok || err != nil
 }
@@ -14,30 +14,41 @@ func banner() {
 	xprintf("Installed Go for %s/%s in %s\n", goos, goarch, goroot)
 	xprintf("Installed commands in %s\n", gobin)
 
+	if msg := gobinProblem(); msg != "" {
+		xprintf("*** %s\n", msg)
+	}
+
+	if !xsamefile(goroot_final, goroot) {
+		xprintf("\n"+
+			"The binaries expect %s to be copied or moved to %s\n",
+			goroot, goroot_final)
+	}
+}
+
+// gobinProblem returns a message explaining why the installed
+// commands in gobin will not be found, or "" if they will.
+func gobinProblem() string {
 	if !xsamefile(goroot_final, goroot) {
 		// If the files are to be moved, don't check that gobin
 		// is on PATH; assume they know what they are doing.
-	} else if gohostos == "plan9" {
+		return ""
+	}
+	if gohostos == "plan9" {
 		// Check that gobin is bound before /bin.
 		pid := strings.Replace(readfile("#c/pid"), " ", "", -1)
 		ns := fmt.Sprintf("/proc/%s/ns", pid)
 		if !strings.Contains(readfile(ns), fmt.Sprintf("bind -b %s /bin", gobin)) {
-			xprintf("*** You need to bind %s before /bin.\n", gobin)
-		}
-	} else {
-		// Check that gobin appears in $PATH.
-		pathsep := ":"
-		if gohostos == "windows" {
-			pathsep = ";"
-		}
-		if !strings.Contains(pathsep+os.Getenv("PATH")+pathsep, pathsep+gobin+pathsep) {
-			xprintf("*** You need to add %s to your PATH.\n", gobin)
+			return fmt.Sprintf("You need to bind %s before /bin.", gobin)
 		}
+		return ""
 	}
-
-	if !xsamefile(goroot_final, goroot) {
-		xprintf("\n"+
-			"The binaries expect %s to be copied or moved to %s\n",
-			goroot, goroot_final)
+	// Check that gobin appears in $PATH.
+	pathsep := ":"
+	if gohostos == "windows" {
+		pathsep = ";"
+	}
+	if !strings.Contains(pathsep+os.Getenv("PATH")+pathsep, pathsep+gobin+pathsep) {
+		return fmt.Sprintf("You need to add %s to your PATH.", gobin)
 	}
+	return ""
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,17 +23,23 @@ import (
 // if $X was already present in os.Environ(), most systems preferred
 // that setting, not the new one.
 func _cmdbootstrap() {
-	timelog("start", "dist bootstrap")
-	defer timelog("end", "dist bootstrap")
-
 	var noBanner bool
 	var debug bool
+	var resume bool
 	flag.BoolVar(&rebuildall, "a", rebuildall, "rebuild all")
 	flag.BoolVar(&debug, "d", debug, "enable debugging of bootstrap process")
 	flag.BoolVar(&noBanner, "no-banner", noBanner, "do not print banner")
+	flag.BoolVar(&resume, "resume", resume, "skip stages completed by an earlier, failed bootstrap")
+	addJobFlags()
 
 	xflagparse(0)
 
+	// The event log is open only once the flags are parsed.
+	// Record the end from an exit handler, so that a failed
+	// bootstrap has an end marker too.
+	logPhase("start", "dist bootstrap")
+	xatexit(func() { logPhase("end", "dist bootstrap") })
+
 	if debug {
 		// cmd/buildid is used in debug mode.
 		toolchain = append(toolchain, "cmd/buildid")
@@ -54,9 +61,44 @@ func _cmdbootstrap() {
 
 	setup()
 
-	timelog("build", "toolchain1")
-	checkCC()
-	bootstrapBuildTools()
+	// The stages completed by a previous run are only reused
+	// if that run was building for the same target.
+	target := goos + "/" + goarch
+	stamp := bootstrapStamp()
+	done := -1
+	if resume {
+		done = lastBootstrapStage(stamp, target)
+		if done >= 0 && vflag > 0 {
+			errprintf("resuming after stage %s\n", bootstrapStages[done])
+		}
+	} else {
+		xremove(stamp)
+	}
+
+	// stage runs f as the bootstrap stage named name,
+	// unless an earlier run already completed it.
+	// On success the stage is recorded in the stamp file,
+	// so that a later -resume can pick up after it.
+	stage := func(name string, f func()) {
+		i := find(name, bootstrapStages)
+		if i <= done {
+			xprintf("Skipping %s (completed by an earlier bootstrap).\n", name)
+			return
+		}
+		curStage = name
+		logPhase("build", name)
+		f()
+		// With -k, a stage may have finished with some
+		// of its packages failed; stop before the next one.
+		checkFailures()
+		curStage = ""
+		writefile(fmt.Sprintf("%s %s\n", target, name), stamp, 0)
+	}
+
+	stage("toolchain1", func() {
+		checkCC()
+		bootstrapBuildTools()
+	})
 
 	// Remember old content of $GOROOT/bin for comparison below.
 	oldBinFiles, _ := filepath.Glob(pathf("%s/bin/*", goroot))
@@ -71,22 +113,23 @@ func _cmdbootstrap() {
 	os.Setenv("GOARCH", goarch)
 	os.Setenv("GOOS", goos)
 
-	timelog("build", "go_bootstrap")
-	xprintf("Building Go bootstrap cmd/go (go_bootstrap) using Go toolchain1.\n")
-
-	install("runtime") // dependency not visible in sources; also sets up textflag.h
+	goBootstrap := pathf("%s/go_bootstrap", tooldir)
+	cmdGo := pathf("%s/go", gobin)
 
-	_logret()
-	return
-	install("cmd/go")
-	if vflag > 0 {
-		xprintf("\n")
-	}
+	stage("go_bootstrap", func() {
+		xprintf("Building Go bootstrap cmd/go (go_bootstrap) using Go toolchain1.\n")
+		install("runtime") // dependency not visible in sources; also sets up textflag.h
+		install("cmd/go")
+		if vflag > 0 {
+			xprintf("\n")
+		}
+	})
 
 	gogcflags = os.Getenv("GO_GCFLAGS") // we were using $BOOT_GO_GCFLAGS until now
 	goldflags = os.Getenv("GO_LDFLAGS")
-	goBootstrap := pathf("%s/go_bootstrap", tooldir)
-	cmdGo := pathf("%s/go", gobin)
+	if !isfile(goBootstrap + exe) {
+		fatalf("%s does not exist; rerun bootstrap without -resume", goBootstrap)
+	}
 	if debug {
 		run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
 		copyfile(pathf("%s/compile1", tooldir), pathf("%s/compile", tooldir), writeExec)
@@ -108,21 +151,19 @@ func _cmdbootstrap() {
 	//
 	//  toolchain2 = mk(new toolchain, toolchain1, go_bootstrap)
 	//
-	timelog("build", "toolchain2")
-	if vflag > 0 {
-		xprintf("\n")
-	}
-	xprintf("Building Go toolchain2 using go_bootstrap and Go toolchain1.\n")
 	os.Setenv("CC", compilerEnvLookup(defaultcc, goos, goarch))
-
-	_pf(1, "goBootstrap=[%s,%v]", goBootstrap, toolchain)
-
-	goInstall(goBootstrap, append([]string{"-i"}, toolchain...)...)
-	if debug {
-		run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
-		run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
-		copyfile(pathf("%s/compile2", tooldir), pathf("%s/compile", tooldir), writeExec)
-	}
+	stage("toolchain2", func() {
+		if vflag > 0 {
+			xprintf("\n")
+		}
+		xprintf("Building Go toolchain2 using go_bootstrap and Go toolchain1.\n")
+		goInstall(goBootstrap, append([]string{"-i"}, toolchain...)...)
+		if debug {
+			run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
+			run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
+			copyfile(pathf("%s/compile2", tooldir), pathf("%s/compile", tooldir), writeExec)
+		}
+	})
 
 	// Toolchain2 should be semantically equivalent to toolchain1,
 	// but it was built using the new compilers instead of the Go 1.4 compilers,
@@ -140,64 +181,67 @@ func _cmdbootstrap() {
 	//
 	//  toolchain3 = mk(new toolchain, toolchain2, go_bootstrap)
 	//
-	timelog("build", "toolchain3")
-	if vflag > 0 {
-		xprintf("\n")
-	}
-	xprintf("Building Go toolchain3 using go_bootstrap and Go toolchain2.\n")
-	goInstall(goBootstrap, append([]string{"-a", "-i"}, toolchain...)...)
-	if debug {
-		run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
-		run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
-		copyfile(pathf("%s/compile3", tooldir), pathf("%s/compile", tooldir), writeExec)
-	}
-	checkNotStale(goBootstrap, append(toolchain, "runtime/internal/sys")...)
-
-	if goos == oldgoos && goarch == oldgoarch {
-		// Common case - not setting up for cross-compilation.
-		timelog("build", "toolchain")
+	stage("toolchain3", func() {
 		if vflag > 0 {
 			xprintf("\n")
 		}
-		xprintf("Building packages and commands for %s/%s.\n", goos, goarch)
-	} else {
-		// GOOS/GOARCH does not match GOHOSTOS/GOHOSTARCH.
-		// Finish GOHOSTOS/GOHOSTARCH installation and then
-		// run GOOS/GOARCH installation.
-		timelog("build", "host toolchain")
-		if vflag > 0 {
-			xprintf("\n")
+		xprintf("Building Go toolchain3 using go_bootstrap and Go toolchain2.\n")
+		goInstall(goBootstrap, append([]string{"-a", "-i"}, toolchain...)...)
+		if debug {
+			run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
+			run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
+			copyfile(pathf("%s/compile3", tooldir), pathf("%s/compile", tooldir), writeExec)
 		}
-		xprintf("Building packages and commands for host, %s/%s.\n", goos, goarch)
-		goInstall(goBootstrap, "std", "cmd")
-		checkNotStale(goBootstrap, "std", "cmd")
-		checkNotStale(cmdGo, "std", "cmd")
-
-		timelog("build", "target toolchain")
-		if vflag > 0 {
-			xprintf("\n")
-		}
-		goos = oldgoos
-		goarch = oldgoarch
-		os.Setenv("GOOS", goos)
-		os.Setenv("GOARCH", goarch)
-		os.Setenv("CC", compilerEnvLookup(defaultcc, goos, goarch))
-		xprintf("Building packages and commands for target, %s/%s.\n", goos, goarch)
-	}
-	targets := []string{"std", "cmd"}
-	if goos == "js" && goarch == "wasm" {
-		// Skip the cmd tools for js/wasm. They're not usable.
-		targets = targets[:1]
-	}
-	goInstall(goBootstrap, targets...)
-	checkNotStale(goBootstrap, targets...)
-	checkNotStale(cmdGo, targets...)
-	if debug {
-		run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
-		run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
 		checkNotStale(goBootstrap, append(toolchain, "runtime/internal/sys")...)
-		copyfile(pathf("%s/compile4", tooldir), pathf("%s/compile", tooldir), writeExec)
-	}
+	})
+
+	stage("std+cmd", func() {
+		if goos == oldgoos && goarch == oldgoarch {
+			// Common case - not setting up for cross-compilation.
+			logPhase("build", "toolchain")
+			if vflag > 0 {
+				xprintf("\n")
+			}
+			xprintf("Building packages and commands for %s/%s.\n", goos, goarch)
+		} else {
+			// GOOS/GOARCH does not match GOHOSTOS/GOHOSTARCH.
+			// Finish GOHOSTOS/GOHOSTARCH installation and then
+			// run GOOS/GOARCH installation.
+			logPhase("build", "host toolchain")
+			if vflag > 0 {
+				xprintf("\n")
+			}
+			xprintf("Building packages and commands for host, %s/%s.\n", goos, goarch)
+			goInstall(goBootstrap, "std", "cmd")
+			checkNotStale(goBootstrap, "std", "cmd")
+			checkNotStale(cmdGo, "std", "cmd")
+
+			logPhase("build", "target toolchain")
+			if vflag > 0 {
+				xprintf("\n")
+			}
+			goos = oldgoos
+			goarch = oldgoarch
+			os.Setenv("GOOS", goos)
+			os.Setenv("GOARCH", goarch)
+			os.Setenv("CC", compilerEnvLookup(defaultcc, goos, goarch))
+			xprintf("Building packages and commands for target, %s/%s.\n", goos, goarch)
+		}
+		targets := []string{"std", "cmd"}
+		if goos == "js" && goarch == "wasm" {
+			// Skip the cmd tools for js/wasm. They're not usable.
+			targets = targets[:1]
+		}
+		goInstall(goBootstrap, targets...)
+		checkNotStale(goBootstrap, targets...)
+		checkNotStale(cmdGo, targets...)
+		if debug {
+			run("", ShowOutput|CheckExit, pathf("%s/compile", tooldir), "-V=full")
+			run("", ShowOutput|CheckExit, pathf("%s/buildid", tooldir), pathf("%s/pkg/%s_%s/runtime/internal/sys.a", goroot, goos, goarch))
+			checkNotStale(goBootstrap, append(toolchain, "runtime/internal/sys")...)
+			copyfile(pathf("%s/compile4", tooldir), pathf("%s/compile", tooldir), writeExec)
+		}
+	})
 
 	// Check that there are no new files in $GOROOT/bin other than
 	// go and gofmt and $GOOS_$GOARCH (target bin when cross-compiling).
@@ -213,8 +257,9 @@ func _cmdbootstrap() {
 		}
 	}
 
-	// Remove go_bootstrap now that we're done.
+	// Remove go_bootstrap and the stage record now that we're done.
 	xremove(pathf("%s/go_bootstrap", tooldir))
+	xremove(stamp)
 
 	// Print trailing banner unless instructed otherwise.
 	if !noBanner {
@@ -222,6 +267,40 @@ func _cmdbootstrap() {
 	}
 }
 
+// bootstrapStages lists the steps of dist bootstrap, in order.
+// Each stage depends only on the outputs of the stages before it,
+// so a failed bootstrap can be resumed after its last completed stage.
+var bootstrapStages = []string{
+	"toolchain1",
+	"go_bootstrap",
+	"toolchain2",
+	"toolchain3",
+	"std+cmd",
+}
+
+// curStage is the bootstrap stage currently running, if any.
+// fatalf reports it so that a failure names the stage it happened in.
+var curStage string
+
+// bootstrapStamp returns the name of the file recording
+// the last bootstrap stage that completed successfully.
+func bootstrapStamp() string {
+	return pathf("%s/pkg/obj/bootstrap-stage", goroot)
+}
+
+// lastBootstrapStage returns the index in bootstrapStages of the stage
+// recorded in stamp, or -1 if there is no usable record for target.
+func lastBootstrapStage(stamp, target string) int {
+	if !isfile(stamp) {
+		return -1
+	}
+	f := strings.Fields(readfile(stamp))
+	if len(f) != 2 || f[0] != target {
+		return -1
+	}
+	return find(f[1], bootstrapStages)
+}
+
 // setup sets up the tree for the initial build.
 func setup() {
 	// Create bin directory.
@@ -298,16 +377,26 @@ func checkCC() {
 	if !needCC() {
 		return
 	}
-	if output, err := exec.Command(defaultcc[""], "--help").CombinedOutput(); err != nil {
-		outputHdr := ""
-		if len(output) > 0 {
-			outputHdr = "\nCommand output:\n\n"
-		}
-		fatalf("cannot invoke C compiler %q: %v\n\n"+
-			"Go needs a system C compiler for use with cgo.\n"+
-			"To set a C compiler, set CC=the-compiler.\n"+
-			"To disable cgo, set CGO_ENABLED=0.\n%s%s", defaultcc[""], err, outputHdr, output)
+	if err := ccError(defaultcc[""]); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// ccError returns an error explaining why the C compiler cc
+// cannot be run, or nil if it can.
+func ccError(cc string) error {
+	output, err := exec.Command(cc, "--help").CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	outputHdr := ""
+	if len(output) > 0 {
+		outputHdr = "\nCommand output:\n\n"
 	}
+	return fmt.Errorf("cannot invoke C compiler %q: %v\n\n"+
+		"Go needs a system C compiler for use with cgo.\n"+
+		"To set a C compiler, set CC=the-compiler.\n"+
+		"To disable cgo, set CGO_ENABLED=0.\n%s%s", cc, err, outputHdr, output)
 }
 
 func needCC() bool {
@@ -1,46 +1,172 @@
 package main
 
 import (
+	"flag"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
+// A cleanSet selects the kinds of files that clean removes.
+type cleanSet struct {
+	generated bool // generated source files, like zversion.go, and command binaries
+	objects   bool // installed packages, object files, bootstrap state and distpack output
+	tools     bool // the tools in $GOROOT/pkg/tool
+	cache     bool // the build cache and the cached version
+
+	// rebuild limits the other kinds to what a full rebuild
+	// must remove, keeping the distpack output and the build cache.
+	rebuild bool
+}
+
+// The clean command removes the generated source files, or, with the
+// selector flags, the kinds of files they select. With -n it lists
+// the paths it would remove, with their sizes, and removes nothing.
+func _cmdclean() {
+	dryRun := flag.Bool("n", false, "print the paths that would be removed, with their sizes, but do not remove them")
+	var sel cleanSet
+	flag.BoolVar(&sel.generated, "generated", false, "remove generated source files and command binaries (the default)")
+	flag.BoolVar(&sel.objects, "objects", false, "remove installed packages, object files, bootstrap state and distpack output")
+	flag.BoolVar(&sel.tools, "tools", false, "remove the tools in $GOROOT/pkg/tool")
+	flag.BoolVar(&sel.cache, "cache", false, "remove the build cache and VERSION.cache")
+	xflagparse(0)
+
+	if sel == (cleanSet{}) {
+		sel.generated = true
+	}
+	paths := cleanPaths(sel)
+	var total int64
+	for _, p := range paths {
+		size := pathSize(p)
+		total += size
+		if *dryRun {
+			xprintf("%12d %s\n", size, p)
+			continue
+		}
+		if vflag > 0 {
+			xprintf("rm %s (%d bytes)\n", p, size)
+		}
+		xremoveall(p)
+	}
+	if *dryRun {
+		xprintf("%12d total\n", total)
+	}
+}
+
+// clean removes the generated source files,
+// and with rebuildall set everything that the build has written.
 func clean() {
+	all := rebuildall
+	for _, p := range cleanPaths(cleanSet{generated: true, objects: all, tools: all, cache: all, rebuild: true}) {
+		xremoveall(p)
+	}
+}
 
-	for _, name := range cleanlist {
-		path := pathf("%s/src/%s", goroot, name)
+// cleanPaths returns the existing files and directories
+// selected by sel, in the order they should be removed.
+// It calls fatalf if any of them is not inside $GOROOT.
+func cleanPaths(sel cleanSet) []string {
+	var paths []string
+	add := func(p string) {
+		if _, err := os.Lstat(p); err == nil && find(p, paths) < 0 {
+			paths = append(paths, p)
+		}
+	}
 
-		// Remove generated files.
-		for _, elem := range xreaddir(path) {
-			for _, gt := range gentab {
-				if strings.HasPrefix(elem, gt.nameprefix) {
-					xremove(pathf("%s/%s", path, elem))
+	if sel.generated {
+		for _, name := range cleanlist {
+			path := pathf("%s/src/%s", goroot, name)
+			if !isdir(path) {
+				continue
+			}
+			for _, elem := range xreaddir(path) {
+				for _, gt := range gentab {
+					if strings.HasPrefix(elem, gt.nameprefix) {
+						add(pathf("%s/%s", path, elem))
+					}
 				}
 			}
 		}
-		// Remove generated binary named for directory.
-		if strings.HasPrefix(name, "cmd/") {
-			xremove(pathf("%s/%s", path, name[4:]))
+		for _, elem := range runtimegen {
+			add(pathf("%s/src/runtime/%s", goroot, elem))
+		}
+
+		// Binaries built in the directories of commands.
+		for _, name := range cleanlist {
+			if strings.HasPrefix(name, "cmd/") {
+				add(pathf("%s/src/%s/%s", goroot, name, name[4:]))
+			}
 		}
 	}
 
-	// remove runtimegen files.
-	path := pathf("%s/src/runtime", goroot)
-	for _, elem := range runtimegen {
-		xremove(pathf("%s/%s", path, elem))
+	if sel.objects {
+		// Object tree.
+		add(pathf("%s/pkg/obj/%s_%s", goroot, gohostos, gohostarch))
+		add(pathf("%s/pkg/obj/dist", goroot))
+
+		// Installed packages.
+		add(pathf("%s/pkg/%s_%s", goroot, gohostos, gohostarch))
+		add(pathf("%s/pkg/%s_%s", goroot, goos, goarch))
+		add(pathf("%s/pkg/%s_%s_race", goroot, gohostos, gohostarch))
+		add(pathf("%s/pkg/%s_%s_race", goroot, goos, goarch))
+
+		// The bootstrap workspace and any partially completed bootstrap.
+		add(pathf("%s/pkg/bootstrap", goroot))
+		add(bootstrapStamp())
+
+		// Distributions built by distpack.
+		if !sel.rebuild {
+			add(pathf("%s/pkg/distpack", goroot))
+		}
 	}
 
-	if rebuildall {
-		// Remove object tree.
-		xremoveall(pathf("%s/pkg/obj/%s_%s", goroot, gohostos, gohostarch))
+	if sel.tools {
+		add(tooldir)
+	}
 
-		// Remove installed packages and tools.
-		xremoveall(pathf("%s/pkg/%s_%s", goroot, gohostos, gohostarch))
-		xremoveall(pathf("%s/pkg/%s_%s", goroot, goos, goarch))
-		xremoveall(pathf("%s/pkg/%s_%s_race", goroot, gohostos, gohostarch))
-		xremoveall(pathf("%s/pkg/%s_%s_race", goroot, goos, goarch))
-		xremoveall(tooldir)
+	if sel.cache {
+		if !sel.rebuild {
+			add(pathf("%s/pkg/obj/go-build", goroot))
+		}
+		add(pathf("%s/VERSION.cache", goroot))
+	}
 
-		// Remove cached version info.
-		xremove(pathf("%s/VERSION.cache", goroot))
+	for _, p := range paths {
+		if !inGoroot(p) {
+			fatalf("refusing to remove %s: not inside $GOROOT (%s)", p, goroot)
+		}
 	}
+	return paths
+}
+
+// inGoroot reports whether the file p is strictly inside $GOROOT,
+// after resolving any symbolic links in the directories leading to it.
+// A symbolic link inside $GOROOT is itself removed, not followed,
+// so only its directory needs to be resolved.
+func inGoroot(p string) bool {
+	root, err := filepath.EvalSymlinks(goroot)
+	if err != nil {
+		return false
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Join(dir, filepath.Base(p)))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// pathSize returns the total size of the regular files in the tree rooted at p.
+func pathSize(p string) int64 {
+	var size int64
+	filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
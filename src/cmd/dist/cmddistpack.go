@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The distpack command builds a Go distribution for another platform.
+//
+// It runs dist bootstrap with GOOS and GOARCH set to the target,
+// which builds the host toolchain and uses it to cross-build std
+// and cmd for the target. It then lays out a self-contained GOROOT
+// for the target in $GOROOT/pkg/distpack/<goos>_<goarch>/go, and
+// packs that tree into a tar.gz next to it, along with a manifest
+// listing the SHA-256 of every file in the archive.
+//
+// The layout is not placed in $GOROOT/pkg/<goos>_<goarch> itself
+// because that is where the target's packages are installed.
+//
+// The archive is deterministic: its entries are sorted, and their
+// owners are root and their modification times $SOURCE_DATE_EPOCH,
+// or the Unix epoch if that is not set. Building the same sources
+// for the same target therefore produces the same bytes.
+func _cmddistpack() {
+	target := flag.String("target", goos+"/"+goarch, "build for goos/goarch")
+	xflagparse(0)
+
+	f := strings.Split(*target, "/")
+	if len(f) != 2 || findPlatform(f[0], f[1]) == nil {
+		fatalf("distpack: unsupported target %q; see 'go tool dist list'", *target)
+	}
+	goos, goarch = f[0], f[1]
+
+	logPhase("build", "distpack "+*target)
+	os.Setenv("GOOS", goos)
+	os.Setenv("GOARCH", goarch)
+	args := []string{os.Args[0], "bootstrap", "-no-banner"}
+	for i := 0; i < vflag; i++ {
+		args = append(args, "-v")
+	}
+	run("", ShowOutput|CheckExit, args...)
+
+	version := findgoversion()
+	dir := pathf("%s/pkg/distpack/%s_%s", goroot, goos, goarch)
+	xremoveall(dir)
+	root := pathf("%s/go", dir)
+	distpackLayout(root, version)
+
+	mtime := time.Unix(0, 0).UTC()
+	if t, ok := sourceDate(); ok {
+		mtime = t
+	}
+	name := pathf("%s/%s.%s-%s", dir, strings.Fields(version)[0], goos, goarch)
+	manifest, sum := distpackTar(dir, "go", name+".tar.gz", mtime)
+	writefile(manifest, name+".manifest", 0)
+	xprintf("%s\n%x  %s\n", name+".manifest", sum, name+".tar.gz")
+}
+
+// distpackLayout creates the GOROOT tree for goos/goarch at root.
+// It holds the sources and other top-level files of $GOROOT,
+// the go and gofmt commands and the tools built for the target,
+// the target's installed packages, and a VERSION file recording
+// version, so that the tree does not need git to know it.
+func distpackLayout(root, version string) {
+	for _, elem := range xreaddir(goroot) {
+		if strings.HasPrefix(elem, ".") || elem == "bin" || elem == "pkg" {
+			continue
+		}
+		distpackCopy(pathf("%s/%s", root, elem), pathf("%s/%s", goroot, elem))
+	}
+	writefile(version, pathf("%s/VERSION", root), 0)
+
+	// The go command for a cross-compiled target is
+	// installed in a subdirectory of $GOROOT/bin.
+	bin := pathf("%s/bin", goroot)
+	if goos != gohostos || goarch != gohostarch {
+		bin = pathf("%s/bin/%s_%s", goroot, goos, goarch)
+	}
+	for _, elem := range []string{"go", "gofmt"} {
+		if p := pathf("%s/%s%s", bin, elem, distpackExe()); isfile(p) {
+			distpackCopy(pathf("%s/bin/%s%s", root, elem, distpackExe()), p)
+		}
+	}
+
+	target := goos + "_" + goarch
+	for _, elem := range []string{"include", target, "tool/" + target} {
+		if p := pathf("%s/pkg/%s", goroot, elem); isdir(p) {
+			distpackCopy(pathf("%s/pkg/%s", root, elem), p)
+		}
+	}
+}
+
+// distpackExe returns the suffix of executables on the target.
+func distpackExe() string {
+	if goos == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// distpackCopy copies the file or directory tree src to dst.
+// Version control metadata and the dist binary built
+// by make.bash in src/cmd/dist are left out.
+func distpackCopy(dst, src string) {
+	xmkdirall(filepath.Dir(dst))
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if name == ".git" || name == ".gitignore" || name == ".gitattributes" || path == pathf("%s/src/cmd/dist/dist%s", goroot, exe) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			xmkdirall(target)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			flag := 0
+			if info.Mode()&0111 != 0 {
+				flag = writeExec
+			}
+			copyfile(target, path, flag)
+		}
+		return nil
+	})
+	if err != nil {
+		fatalf("distpack: %v", err)
+	}
+}
+
+// distpackTar writes the tree dir/elem to the gzip-compressed tar
+// archive file, with entries named relative to dir. It returns a
+// manifest of the files in the archive, in the format of sha256sum,
+// and the SHA-256 of the archive itself.
+func distpackTar(dir, elem, file string, mtime time.Time) (manifest string, sum []byte) {
+	var manifestBuf bytes.Buffer
+	f, err := os.Create(file)
+	if err != nil {
+		fatalf("distpack: %v", err)
+	}
+	h := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(f, h))
+	tw := tar.NewWriter(zw)
+
+	// filepath.Walk visits the files in lexical order,
+	// which makes the archive deterministic.
+	err = filepath.Walk(pathf("%s/%s", dir, elem), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			Mode:    0644,
+			ModTime: mtime,
+		}
+		var data []byte
+		switch {
+		case info.IsDir():
+			hdr.Name += "/"
+			hdr.Mode = 0755
+			hdr.Typeflag = tar.TypeDir
+		case info.Mode()&os.ModeSymlink != 0:
+			hdr.Mode = 0777
+			hdr.Typeflag = tar.TypeSymlink
+			if hdr.Linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 {
+				hdr.Mode = 0755
+			}
+			hdr.Typeflag = tar.TypeReg
+			data = []byte(readfile(path))
+			hdr.Size = int64(len(data))
+			fmt.Fprintf(&manifestBuf, "%x  %s\n", sha256.Sum256(data), hdr.Name)
+		default:
+			return fmt.Errorf("%s: unsupported file type %v", path, info.Mode())
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fatalf("distpack: %v", err)
+	}
+	return manifestBuf.String(), h.Sum(nil)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A doctorCheck is the result of one check made by dist doctor.
+type doctorCheck struct {
+	Name   string
+	Status string // "ok", "warn", or "fail"
+	Detail string `json:",omitempty"`
+}
+
+// doctorChecks accumulates the results of dist doctor.
+type doctorChecks []doctorCheck
+
+func (d *doctorChecks) add(status, name, format string, args ...interface{}) {
+	*d = append(*d, doctorCheck{name, status, fmt.Sprintf(format, args...)})
+}
+
+func (d *doctorChecks) ok(name, format string, args ...interface{}) {
+	d.add("ok", name, format, args...)
+}
+
+func (d *doctorChecks) warn(name, format string, args ...interface{}) {
+	d.add("warn", name, format, args...)
+}
+
+func (d *doctorChecks) fail(name, format string, args ...interface{}) {
+	d.add("fail", name, format, args...)
+}
+
+// cmddoctor checks the host environment for the problems that
+// would otherwise only be found partway through bootstrap:
+// an unknown GOOS or GOARCH, a missing bootstrap toolchain or
+// C compiler, bad GOARM, GO386 or GOMIPS values, and so on.
+// Unlike the other commands it keeps going after a problem,
+// and exits with status 1 if any check failed.
+func _cmddoctor() {
+	jsonFlag := flag.Bool("json", false, "produce JSON output")
+	xflagparse(0)
+
+	var d doctorChecks
+	for _, p := range initProblems {
+		d.fail("environment", "%s", p)
+	}
+	doctorGoroot(&d)
+	doctorPlatform(&d)
+	doctorBootstrap(&d)
+	doctorCC(&d)
+	if msg := gobinProblem(); msg != "" {
+		d.warn("PATH", "%s", msg)
+	} else {
+		d.ok("PATH", "%s", gobin)
+	}
+
+	failed := false
+	for _, c := range d {
+		if c.Status == "fail" {
+			failed = true
+		}
+	}
+
+	if *jsonFlag {
+		out, err := json.MarshalIndent(d, "", "\t")
+		if err != nil {
+			fatalf("json marshal error: %v", err)
+		}
+		if _, err := os.Stdout.Write(append(out, '\n')); err != nil {
+			fatalf("write failed: %v", err)
+		}
+	} else {
+		for _, c := range d {
+			status := c.Status
+			if status == "fail" {
+				status = "FAIL"
+			}
+			// Indent any continuation lines of the detail
+			// under the first one.
+			detail := strings.Replace(strings.TrimSpace(c.Detail), "\n", "\n"+strings.Repeat(" ", 24), -1)
+			xprintf("%-5s %-17s %s\n", status, c.Name, detail)
+		}
+	}
+	if failed {
+		xexit(1)
+	}
+}
+
+// doctorGoroot checks the layout of $GOROOT itself.
+func doctorGoroot(d *doctorChecks) {
+	if !isfile(pathf("%s/src/all.bash", goroot)) {
+		// Already reported by xinit.
+		return
+	}
+	d.ok("GOROOT", "%s", goroot)
+
+	if p := pathf("%s/src/pkg", goroot); isdir(p) {
+		d.fail("src/pkg", "%s still exists and probably contains stale files; see https://golang.org/s/go14nopkg", p)
+	}
+
+	// The build writes to $GOROOT/pkg; make sure it can.
+	dir := pathf("%s/pkg", goroot)
+	if !isdir(dir) {
+		dir = goroot
+	}
+	if f, err := ioutil.TempFile(dir, "dist-doctor-"); err != nil {
+		d.fail("GOROOT writable", "%v", err)
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+		d.ok("GOROOT writable", "%s", dir)
+	}
+
+	switch {
+	case isfile(pathf("%s/VERSION", goroot)):
+		d.ok("VERSION", "%s", strings.TrimSpace(readfile(pathf("%s/VERSION", goroot))))
+	case isGitRepo():
+		d.ok("VERSION", "from git")
+	default:
+		d.fail("VERSION", "no %s/VERSION file and not a git repository", goroot)
+	}
+}
+
+// doctorPlatform checks the target platform and the
+// settings that are specific to its architecture.
+func doctorPlatform(d *doctorChecks) {
+	plats := []string{gohostos + "/" + gohostarch}
+	if goos != gohostos || goarch != gohostarch {
+		plats = append(plats, goos+"/"+goarch)
+	}
+	for i, p := range plats {
+		name := "host platform"
+		if i > 0 {
+			name = "target platform"
+		}
+		if _, ok := cgoEnabled[p]; !ok {
+			d.fail(name, "%s is not a supported platform", p)
+		} else if incomplete[p] {
+			d.warn(name, "%s is an incomplete port", p)
+		} else {
+			d.ok(name, "%s", p)
+		}
+	}
+
+	switch goarch {
+	case "arm":
+		switch goarm {
+		case "5", "6", "7":
+			d.ok("GOARM", "%s", goarm)
+		default:
+			d.fail("GOARM", "invalid GOARM=%s; want 5, 6, or 7", goarm)
+		}
+	case "386":
+		switch go386 {
+		case "387", "sse2":
+			d.ok("GO386", "%s", go386)
+		default:
+			d.fail("GO386", "invalid GO386=%s; want 387 or sse2", go386)
+		}
+	case "mips", "mipsle":
+		doctorFloat(d, "GOMIPS", gomips)
+	case "mips64", "mips64le":
+		doctorFloat(d, "GOMIPS64", gomips64)
+	}
+
+	if os.Getenv("CGO_ENABLED") == "1" && !cgoEnabled[goos+"/"+goarch] {
+		d.warn("CGO_ENABLED", "CGO_ENABLED=1 but cgo is not supported on %s/%s", goos, goarch)
+	}
+}
+
+func doctorFloat(d *doctorChecks, name, value string) {
+	switch value {
+	case "hardfloat", "softfloat":
+		d.ok(name, "%s", value)
+	default:
+		d.fail(name, "invalid %s=%s; want hardfloat or softfloat", name, value)
+	}
+}
+
+// doctorBootstrap checks the Go toolchain used to build toolchain1.
+func doctorBootstrap(d *doctorChecks) {
+	root := bootstrapRoot()
+	gobin := pathf("%s/bin/go%s", root, exe)
+	switch {
+	case os.Getenv("GOROOT_BOOTSTRAP") == "" && !isfile(gobin):
+		d.fail("GOROOT_BOOTSTRAP", "not set, and no Go toolchain in the default %s", root)
+	case !isfile(gobin):
+		d.fail("GOROOT_BOOTSTRAP", "%s does not exist", gobin)
+	case xsamefile(root, goroot):
+		d.fail("GOROOT_BOOTSTRAP", "%s is the same as GOROOT; bootstrap from a separate Go installation", root)
+	default:
+		cmd := exec.Command(gobin, "version")
+		cmd.Env = append(os.Environ(), "GOROOT="+root)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			d.fail("GOROOT_BOOTSTRAP", "%s version: %v", gobin, err)
+		} else {
+			d.ok("GOROOT_BOOTSTRAP", "%s (%s)", root, strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+// doctorCC checks the C compilers, which are only needed if cgo is enabled.
+func doctorCC(d *doctorChecks) {
+	if !needCC() {
+		d.ok("CC", "not needed: cgo is disabled")
+		return
+	}
+	if err := ccError(defaultcc[""]); err != nil {
+		d.fail("CC", "%v", err)
+	} else {
+		d.ok("CC", "%s", defaultcc[""])
+	}
+
+	// The compiler for the target, if it has its own, is only
+	// run when building cgo packages for the target.
+	if cc := compilerEnvLookup(defaultcc, goos, goarch); cc != defaultcc[""] {
+		if err := lookCompiler(cc); err != nil {
+			d.warn("CC_FOR_TARGET", "%s: %v", cc, err)
+		} else {
+			d.ok("CC_FOR_TARGET", "%s", cc)
+		}
+	}
+	if err := lookCompiler(compilerEnvLookup(defaultcxx, goos, goarch)); err != nil {
+		d.warn("CXX", "%v; needed only for cgo packages with C++ sources", err)
+	}
+	if _, err := exec.LookPath(defaultpkgconfig); err != nil {
+		d.warn("PKG_CONFIG", "%v; needed only for cgo packages using #cgo pkg-config", err)
+	}
+}
+
+// lookCompiler reports whether the command in the compiler
+// setting cc, which may include arguments, can be found.
+func lookCompiler(cc string) error {
+	f := strings.Fields(cc)
+	if len(f) == 0 {
+		return fmt.Errorf("no compiler set")
+	}
+	_, err := exec.LookPath(f[0])
+	return err
+}
@@ -1,54 +1,165 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
+// An envVar is one setting printed by dist env.
+type envVar struct {
+	Name   string `json:"-"`
+	Value  string
+	Origin string // where the value came from, for -why
+}
+
 func _cmdenv() {
 	path := flag.Bool("p", false, "emit updated PATH")
 	plan9 := flag.Bool("9", false, "emit plan 9 syntax")
 	windows := flag.Bool("w", false, "emit windows syntax")
+	fish := flag.Bool("fish", false, "emit fish syntax")
+	powershell := flag.Bool("powershell", false, "emit PowerShell syntax")
+	jsonFlag := flag.Bool("json", false, "emit JSON")
+	why := flag.Bool("why", false, "say where each value came from")
 	xflagparse(0)
 
-	format := "%s=\"%s\"\n"
+	vars := envVars(*path)
+
+	if *jsonFlag {
+		// Without -why, the same shape as go env -json.
+		var v interface{}
+		if *why {
+			m := make(map[string]envVar)
+			for _, e := range vars {
+				m[e.Name] = e
+			}
+			v = m
+		} else {
+			m := make(map[string]string)
+			for _, e := range vars {
+				m[e.Name] = e.Value
+			}
+			v = m
+		}
+		out, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			fatalf("json marshal error: %v", err)
+		}
+		if _, err := os.Stdout.Write(append(out, '\n')); err != nil {
+			fatalf("write failed: %v", err)
+		}
+		return
+	}
+
+	// With -why, each line is preceded by a comment
+	// saying where it came from, so that the output
+	// can still be evaluated by the shell.
+	format, comment := "%s=\"%s\"\n", "# %s\n"
+	quote := func(s string) string { return s }
 	switch {
 	case *plan9:
 		format = "%s='%s'\n"
 	case *windows:
-		format = "set %s=%s\r\n"
-	}
-
-	xprintf(format, "GOARCH", goarch)
-	xprintf(format, "GOBIN", gobin)
-	xprintf(format, "GOCACHE", os.Getenv("GOCACHE"))
-	xprintf(format, "GODEBUG", os.Getenv("GODEBUG"))
-	xprintf(format, "GOHOSTARCH", gohostarch)
-	xprintf(format, "GOHOSTOS", gohostos)
-	xprintf(format, "GOOS", goos)
-	xprintf(format, "GOPROXY", os.Getenv("GOPROXY"))
-	xprintf(format, "GOROOT", goroot)
-	xprintf(format, "GOTMPDIR", os.Getenv("GOTMPDIR"))
-	xprintf(format, "GOTOOLDIR", tooldir)
+		format, comment = "set %s=%s\r\n", "rem %s\r\n"
+	case *fish:
+		format = "set -gx %s '%s'\n"
+		quote = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+	case *powershell:
+		format = "$env:%s = '%s'\n"
+		quote = strings.NewReplacer(`'`, `''`).Replace
+	}
+	for _, e := range vars {
+		if *why {
+			xprintf(comment, e.Origin)
+		}
+		xprintf(format, e.Name, quote(e.Value))
+	}
+}
+
+// envVars returns the settings printed by dist env,
+// including $PATH if path is set.
+func envVars(path bool) []envVar {
+	var vars []envVar
+	add := func(name, value, origin string) {
+		vars = append(vars, envVar{name, value, origin})
+	}
+	// setting adds a variable whose origin xinit recorded.
+	setting := func(name, value string) {
+		origin := settingOrigin[name]
+		if origin == "" {
+			origin = "default"
+		}
+		add(name, value, origin)
+	}
+	// passed adds a variable that dist only passes on.
+	passed := func(name string) {
+		if v := os.Getenv(name); v != "" {
+			add(name, v, "environment")
+		} else {
+			add(name, "", "unset")
+		}
+	}
+
+	setting("GOARCH", goarch)
+	setting("GOBIN", gobin)
+	add("GOCACHE", os.Getenv("GOCACHE"), "set by dist ($GOROOT/pkg/obj/go-build)")
+	passed("GODEBUG")
+	setting("GOHOSTARCH", gohostarch)
+	setting("GOHOSTOS", gohostos)
+	setting("GOOS", goos)
+	passed("GOPROXY")
+	setting("GOROOT", goroot)
+	passed("GOTMPDIR")
+	add("GOTOOLDIR", tooldir, "derived ($GOROOT/pkg/tool/$GOHOSTOS_$GOHOSTARCH)")
 	if goarch == "arm" {
-		xprintf(format, "GOARM", goarm)
+		setting("GOARM", goarm)
 	}
 	if goarch == "386" {
-		xprintf(format, "GO386", go386)
+		setting("GO386", go386)
 	}
 	if goarch == "mips" || goarch == "mipsle" {
-		xprintf(format, "GOMIPS", gomips)
+		setting("GOMIPS", gomips)
 	}
 	if goarch == "mips64" || goarch == "mips64le" {
-		xprintf(format, "GOMIPS64", gomips64)
+		setting("GOMIPS64", gomips64)
 	}
 
-	if *path {
+	// The values xinit derives for building cgo and external linking.
+	add("CC", compilerEnvLookup(defaultcc, goos, goarch), compilerOrigin("CC"))
+	add("CXX", compilerEnvLookup(defaultcxx, goos, goarch), compilerOrigin("CXX"))
+	setting("CFLAGS", defaultcflags)
+	setting("LDFLAGS", defaultldflags)
+	setting("PKG_CONFIG", defaultpkgconfig)
+	setting("GO_EXTLINK_ENABLED", goextlinkenabled)
+	add("WORK", workdir, "temporary directory made by this run of dist, in $GOTMPDIR if set")
+
+	if path {
 		sep := ":"
 		if gohostos == "windows" {
 			sep = ";"
 		}
-		xprintf(format, "PATH", fmt.Sprintf("%s%s%s", gobin, sep, os.Getenv("PATH")))
+		add("PATH", fmt.Sprintf("%s%s%s", gobin, sep, os.Getenv("PATH")), "$GOBIN prepended to $PATH")
+	}
+	return vars
+}
+
+// compilerOrigin says where the value of the compiler setting
+// envName, CC or CXX, for the target comes from, following the
+// rules of compilerEnv and compilerEnvLookup.
+func compilerOrigin(envName string) string {
+	for _, name := range []string{
+		envName + "_FOR_" + goos + "_" + goarch,
+		envName + "_FOR_TARGET",
+		envName,
+	} {
+		if os.Getenv(name) != "" {
+			return "environment ($" + name + ")"
+		}
+	}
+	if defaultclang {
+		return "default (clang on " + gohostos + ")"
 	}
+	return "default"
 }
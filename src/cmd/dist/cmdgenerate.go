@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cmdgenerate regenerates every file listed in gentab.
+// With -check it writes nothing, and instead reports the generated
+// files whose content on disk differs from what the generators
+// produce now, exiting with status 1 if there are any.
+// With -v it also prints the inputs each file was generated from.
+func _cmdgenerate() {
+	check := flag.Bool("check", false, "report out-of-date files instead of writing them")
+	xflagparse(0)
+
+	stale := 0
+	for _, gt := range gentab {
+		if gt.gen == nil {
+			continue
+		}
+		for _, name := range gt.files {
+			dir := filepath.ToSlash(filepath.Dir(name))
+			file := pathf("%s/src/%s", goroot, name)
+			if !isdir(filepath.Dir(file)) {
+				// Package not present in this tree.
+				continue
+			}
+			text := gt.gen(dir, file)
+			old := ""
+			if isfile(file) {
+				old = readfile(file)
+			}
+			if vflag > 0 {
+				xprintf("%s\n", name)
+				for _, in := range gt.inputs {
+					xprintf("\t%s\n", explainInput(in))
+				}
+			}
+			if text == old {
+				continue
+			}
+			if !*check {
+				writefile(text, file, 0)
+				xprintf("updated %s\n", name)
+				continue
+			}
+			stale++
+			if old == "" {
+				xprintf("%s: missing\n", name)
+			} else {
+				xprintf("%s: out of date\n%s", name, lineDiff(old, text))
+			}
+		}
+	}
+	if stale > 0 {
+		errprintf("%d generated files out of date; run 'go tool dist generate'\n", stale)
+		xexit(1)
+	}
+}
+
+// generate writes file, in package dir, using the generator
+// in gentab for its name. The file is only rewritten if its
+// content changes.
+func generate(dir, file string) {
+	name := filepath.Base(file)
+	for _, gt := range gentab {
+		if gt.gen != nil && strings.HasPrefix(name, gt.nameprefix) {
+			writefile(gt.gen(dir, file), file, writeSkipSame)
+			return
+		}
+	}
+	fatalf("no generator for %s", file)
+}
+
+// explainInput describes the current value of a generator input.
+func explainInput(in string) string {
+	if !strings.HasPrefix(in, "$") {
+		switch in {
+		case "version":
+			return "version " + findgoversion()
+		case "platforms table":
+			return "platforms table in cmd/dist/platform.go"
+		}
+		return in
+	}
+	name := strings.NewReplacer("${GOOS}", goos, "${GOARCH}", goarch).Replace(in[1:])
+	v := os.Getenv(name)
+	if v == "" {
+		return name + " unset"
+	}
+	return fmt.Sprintf("%s=%q", name, v)
+}
+
+// lineDiff returns the lines that differ between old and new,
+// prefixed with - and + like a diff, based on their longest
+// common subsequence of lines. Generated files are small,
+// so the quadratic algorithm is fine.
+func lineDiff(old, new string) string {
+	a := strings.SplitAfter(old, "\n")
+	b := strings.SplitAfter(new, "\n")
+
+	// lcs[i][j] is the length of the longest common
+	// subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	line := func(prefix, s string) {
+		if s == "" {
+			return // after the final newline
+		}
+		buf.WriteString(prefix + s)
+		if !strings.HasSuffix(s, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", a[i])
+			i++
+		default:
+			line("+", b[j])
+			j++
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A graphNode is one package in the dependency graph printed by dist graph.
+type graphNode struct {
+	Dir       string
+	Bootstrap bool              `json:",omitempty"` // listed in bootstrapDirs
+	External  bool              `json:",omitempty"` // provided by $GOROOT_BOOTSTRAP (-bootstrap only)
+	Files     []string          `json:",omitempty"` // files that are built, relative to Dir
+	Generated []string          `json:",omitempty"` // files that install would generate first
+	Excluded  map[string]string `json:",omitempty"` // files that are not built, and why
+	Imports   []string          `json:",omitempty"`
+	Error     string            `json:",omitempty"`
+}
+
+// cmdgraph prints the package dependency graph walked by install,
+// without building anything.
+func _cmdgraph() {
+	jsonFlag := flag.Bool("json", false, "produce JSON output instead of DOT")
+	bootstrap := flag.Bool("bootstrap", false, "graph the bootstrapDirs built with $GOROOT_BOOTSTRAP")
+	xflagparse(-1)
+
+	roots := flag.Args()
+	if len(roots) == 0 {
+		if *bootstrap {
+			roots = bootstrapDirs
+		} else {
+			// What dist bootstrap installs to build go_bootstrap.
+			roots = []string{"runtime", "cmd/go"}
+		}
+	}
+
+	graph := map[string]*graphNode{}
+	var walk func(dir string)
+	walk = func(dir string) {
+		if graph[dir] != nil {
+			return
+		}
+		if *bootstrap && !strings.HasPrefix(dir, "cmd/") && find(dir, bootstrapDirs) < 0 {
+			// The Go 1.4 toolchain supplies its own copy.
+			graph[dir] = &graphNode{Dir: dir, External: true}
+			return
+		}
+		n := graphDir(dir, *bootstrap)
+		graph[dir] = n
+		for _, imp := range n.Imports {
+			walk(imp)
+		}
+	}
+	for _, dir := range roots {
+		walk(dir)
+	}
+
+	var dirs []string
+	for dir := range graph {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	if *jsonFlag {
+		var nodes []*graphNode
+		for _, dir := range dirs {
+			nodes = append(nodes, graph[dir])
+		}
+		out, err := json.MarshalIndent(nodes, "", "\t")
+		if err != nil {
+			fatalf("json marshal error: %v", err)
+		}
+		out = append(out, '\n')
+		if _, err := os.Stdout.Write(out); err != nil {
+			fatalf("write failed: %v", err)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph dist {\n")
+	for _, dir := range dirs {
+		n := graph[dir]
+		label := fmt.Sprintf("%s\n%d files", dir, len(n.Files))
+		if n.External {
+			label = dir + "\n$GOROOT_BOOTSTRAP"
+		}
+		attrs := fmt.Sprintf("label=%q", label)
+		if len(n.Files) > 0 {
+			attrs += fmt.Sprintf(" tooltip=%q", strings.Join(n.Files, " "))
+		}
+		switch {
+		case n.Error != "":
+			attrs += " color=red"
+		case n.External:
+			attrs += " style=dashed"
+		case n.Bootstrap:
+			attrs += " style=filled fillcolor=lightgrey"
+		}
+		fmt.Fprintf(&buf, "\t%q [%s];\n", dir, attrs)
+		for _, imp := range n.Imports {
+			fmt.Fprintf(&buf, "\t%q -> %q;\n", dir, imp)
+		}
+	}
+	fmt.Fprintf(&buf, "}\n")
+	xprintf("%s", buf.String())
+}
+
+// graphDir returns the graph node for dir, selecting its files
+// the same way runInstall does. If bootstrap is set, the files
+// that bootstrapBuildTools does not copy are excluded as well.
+func graphDir(dir string, bootstrap bool) *graphNode {
+	n := &graphNode{
+		Dir:       dir,
+		Bootstrap: find(dir, bootstrapDirs) >= 0,
+		Excluded:  map[string]string{},
+	}
+	if dir == "unsafe" {
+		return n
+	}
+	path := pathf("%s/src/%s", goroot, dir)
+	if !isdir(path) {
+		n.Error = "no such directory"
+		return n
+	}
+
+	var imports []string
+	for _, p := range candidateFiles(dir, path) {
+		rel := p
+		if r, err := filepath.Rel(path, p); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+		if !isfile(p) {
+			n.Generated = append(n.Generated, rel)
+			continue
+		}
+		why := excludeReason(p, dir)
+		if bootstrap && why == "package main" {
+			// The Go 1.4 go command builds the commands itself.
+			why = ""
+		}
+		if why != "" {
+			n.Excluded[rel] = why
+			continue
+		}
+		if bootstrap {
+			for _, suf := range ignoreSuffixes {
+				if strings.HasSuffix(rel, suf) {
+					n.Excluded[rel] = "not copied for bootstrap"
+					break
+				}
+			}
+			if n.Excluded[rel] != "" {
+				continue
+			}
+		}
+		n.Files = append(n.Files, rel)
+		if strings.HasSuffix(p, ".go") {
+			imports = append(imports, readimports(p)...)
+		}
+	}
+	n.Imports = uniq(imports)
+	return n
+}
@@ -6,11 +6,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 )
 
 func _cmdinstall() {
+	tags := flag.String("tags", "", "build tags to consider satisfied, separated by commas")
+	addJobFlags()
 	xflagparse(-1)
+	setBuildTags(*tags)
 
 	if flag.NArg() == 0 {
 		install(defaulttarg())
@@ -43,6 +45,25 @@ func install(dir string) {
 	<-startInstall(dir)
 }
 
+// failInstall records that the install of dir failed.
+// If why is not empty, it is added to the failure summary;
+// otherwise the failing command has already been recorded.
+func failInstall(dir, why string) {
+	installedMu.Lock()
+	failedInstalls[dir] = true
+	installedMu.Unlock()
+	if why != "" {
+		addFailure(dir, why)
+	}
+}
+
+// installFailed reports whether the install of dir failed.
+func installFailed(dir string) bool {
+	installedMu.Lock()
+	defer installedMu.Unlock()
+	return failedInstalls[dir]
+}
+
 func startInstall(dir string) chan struct{} {
 	installedMu.Lock()
 	ch := installed[dir]
@@ -55,6 +76,50 @@ func startInstall(dir string) chan struct{} {
 	return ch
 }
 
+// candidateFiles returns the absolute names of the files that may be
+// sources for the target built from dir, whose full path is path:
+// everything in that directory with a source suffix,
+// plus any target-specific additions from deptab.
+// The caller still has to apply shouldbuild to the result.
+func candidateFiles(dir, path string) []string {
+	files := xreaddir(path)
+
+	// Remove files beginning with . or _,
+	// which are likely to be editor temporary files.
+	// This is the same heuristic build.ScanDir uses.
+	// There do exist real C files beginning with _,
+	// so limit that check to just Go files.
+	files = filter(files, func(p string) bool {
+		return !strings.HasPrefix(p, ".") && (!strings.HasPrefix(p, "_") || !strings.HasSuffix(p, ".go"))
+	})
+
+	for _, dt := range deptab {
+		if dir == dt.prefix || strings.HasSuffix(dt.prefix, "/") && strings.HasPrefix(dir, dt.prefix) {
+			for _, p := range dt.dep {
+				p = os.ExpandEnv(p)
+				files = append(files, p)
+			}
+		}
+	}
+	files = uniq(files)
+
+	// Convert to absolute paths.
+	for i, p := range files {
+		if !filepath.IsAbs(p) {
+			files[i] = pathf("%s/%s", path, p)
+		}
+	}
+
+	return filter(files, func(p string) bool {
+		for _, suf := range depsuffix {
+			if strings.HasSuffix(p, suf) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 // runInstall installs the library, package, or binary associated with dir,
 // which is relative to $GOROOT/src.
 func runInstall(dir string, ch chan struct{}) {
@@ -77,6 +142,7 @@ func runInstall(dir string, ch chan struct{}) {
 	}
 
 	workdir := pathf("%s/%s", workdir, dir)
+	xmkdirall(workdir)
 
 	var clean []string
 	defer func() {
@@ -113,53 +179,11 @@ func runInstall(dir string, ch chan struct{}) {
 		link = []string{pathf("%s/link", tooldir), "-o", pathf("%s/%s%s", tooldir, elem, exe)}
 		targ = len(link) - 1
 	}
-	_p(1, "link=", dir, link, "ttarg=", link[targ])
-	ttarg := mtime(link[targ])
-
-	// Gather files that are sources for this target.
-	// Everything in that directory, and any target-specific
-	// additions.
-	files := xreaddir(path)
-
-	// Remove files beginning with . or _,
-	// which are likely to be editor temporary files.
-	// This is the same heuristic build.ScanDir uses.
-	// There do exist real C files beginning with _,
-	// so limit that check to just Go files.
-	files = filter(files, func(p string) bool {
-		return !strings.HasPrefix(p, ".") && (!strings.HasPrefix(p, "_") || !strings.HasSuffix(p, ".go"))
-	})
-
-	for _, dt := range deptab {
-		if dir == dt.prefix || strings.HasSuffix(dt.prefix, "/") && strings.HasPrefix(dir, dt.prefix) {
-			for _, p := range dt.dep {
-				p = os.ExpandEnv(p)
-				files = append(files, p)
-			}
-		}
-	}
-	files = uniq(files)
-
-	// Convert to absolute paths.
-	for i, p := range files {
-		if !filepath.IsAbs(p) {
-			files[i] = pathf("%s/%s", path, p)
-		}
-	}
-
-	// Is the target up-to-date?
+	// Select the files that are part of this build.
 	var gofiles, sfiles, missing []string
-	stale := rebuildall
-	files = filter(files, func(p string) bool {
-		for _, suf := range depsuffix {
-			if strings.HasSuffix(p, suf) {
-				goto ok
-			}
-		}
-		return false
-	ok:
-		t := mtime(p)
-		if !t.IsZero() && !strings.HasSuffix(p, ".a") && !shouldbuild(p, dir) {
+	files := filter(candidateFiles(dir, path), func(p string) bool {
+		exists := isfile(p)
+		if exists && !strings.HasSuffix(p, ".a") && !shouldbuild(p, dir) {
 			return false
 		}
 		if strings.HasSuffix(p, ".go") {
@@ -167,39 +191,32 @@ func runInstall(dir string, ch chan struct{}) {
 		} else if strings.HasSuffix(p, ".s") {
 			sfiles = append(sfiles, p)
 		}
-		if t.After(ttarg) {
-			stale = true
-		}
-		if t.IsZero() {
+		if !exists {
 			missing = append(missing, p)
 		}
 		return true
 	})
 
-	_p(1, "install remains file:", files)
-
 	// If there are no files to compile, we're done.
 	if len(files) == 0 {
 		return
 	}
 
-	if !stale {
-		return
-	}
-
 	// For package runtime, copy some files into the work space.
 	if dir == "runtime" {
 		xmkdirall(pathf("%s/pkg/include", goroot))
 		// For use by assembly and C files.
 		copyfile(pathf("%s/pkg/include/textflag.h", goroot),
-			pathf("%s/src/runtime/textflag.h", goroot), 0)
+			pathf("%s/src/runtime/textflag.h", goroot), writeSkipSame)
 		copyfile(pathf("%s/pkg/include/funcdata.h", goroot),
-			pathf("%s/src/runtime/funcdata.h", goroot), 0)
+			pathf("%s/src/runtime/funcdata.h", goroot), writeSkipSame)
 		copyfile(pathf("%s/pkg/include/asm_ppc64x.h", goroot),
-			pathf("%s/src/runtime/asm_ppc64x.h", goroot), 0)
+			pathf("%s/src/runtime/asm_ppc64x.h", goroot), writeSkipSame)
 	}
 
 	// Generate any missing files; regenerate existing ones.
+	// The generators do not rewrite files whose content is unchanged,
+	// and the generated content must be known to decide staleness.
 	for _, p := range files {
 		elem := filepath.Base(p)
 		for _, gt := range gentab {
@@ -210,7 +227,7 @@ func runInstall(dir string, ch chan struct{}) {
 				if vflag > 1 {
 					errprintf("generate %s\n", p)
 				}
-				gt.gen(path, p)
+				writefile(gt.gen(path, p), p, writeSkipSame)
 				// Do not add generated file to clean list.
 				// In runtime, we want to be able to
 				// build the package with the go tool,
@@ -229,17 +246,29 @@ func runInstall(dir string, ch chan struct{}) {
 	}
 
 	// Make sure dependencies are installed.
+	// Their targets are inputs to this one, so they are
+	// installed before deciding whether this target is stale.
 	var deps []string
 	for _, p := range gofiles {
-		deps = append(deps, readimports(p)...)
+		imports := readimports(p)
+		if find("C", imports) >= 0 {
+			fatalf("%s uses cgo, which dist cannot build", p)
+		}
+		deps = append(deps, imports...)
 	}
-	_p(0, "deps=", deps)
+	deps = uniq(deps)
 	for _, dir1 := range deps {
 		startInstall(dir1)
 	}
 	for _, dir1 := range deps {
 		install(dir1)
 	}
+	for _, dir1 := range deps {
+		if installFailed(dir1) {
+			failInstall(dir, "not built: dependency "+dir1+" failed")
+			return
+		}
+	}
 
 	if goos != gohostos || goarch != gohostarch {
 		// We've generated the right files; the go command can do the build.
@@ -249,6 +278,31 @@ func runInstall(dir string, ch chan struct{}) {
 		return
 	}
 
+	// Is the target up-to-date?
+	// Assembly files include headers from the package directory
+	// and from $GOROOT/pkg/include, which is copied from runtime.
+	inputs := files
+	tools := []string{"compile"}
+	if len(sfiles) > 0 {
+		tools = append(tools, "asm")
+		hdrs, _ := filepath.Glob(pathf("%s/*.h", path))
+		for _, h := range []string{"textflag.h", "funcdata.h", "asm_ppc64x.h"} {
+			hdrs = append(hdrs, pathf("%s/src/runtime/%s", goroot, h))
+		}
+		inputs = append(inputs[:len(inputs):len(inputs)], uniq(hdrs)...)
+	}
+	if !ispackcmd {
+		tools = append(tools, "link")
+	}
+	manifest := buildManifest(dir, inputs, deps, tools)
+	stale, why := isStale(dir, link[targ], manifest)
+	if !stale {
+		return
+	}
+	if vflag > 1 {
+		errprintf("stale %s: %s\n", dir, why)
+	}
+
 	asmArgs := []string{
 		pathf("%s/asm", tooldir),
 		"-I", workdir,
@@ -261,7 +315,7 @@ func runInstall(dir string, ch chan struct{}) {
 		// Define GOMIPS_value from gomips.
 		asmArgs = append(asmArgs, "-D", "GOMIPS_"+gomips)
 	}
-	if goarch == "mips64" || goarch == "mipsle64" {
+	if goarch == "mips64" || goarch == "mips64le" {
 		// Define GOMIPS64_value from gomips64.
 		asmArgs = append(asmArgs, "-D", "GOMIPS64_"+gomips64)
 	}
@@ -271,14 +325,17 @@ func runInstall(dir string, ch chan struct{}) {
 	var symabis string
 	if len(sfiles) > 0 {
 		symabis = pathf("%s/symabis", workdir)
-		var wg sync.WaitGroup
+		var g jobGroup
 		asmabis := append(asmArgs[:len(asmArgs):len(asmArgs)], "-gensymabis", "-o", symabis)
 		asmabis = append(asmabis, sfiles...)
 		if err := ioutil.WriteFile(goasmh, nil, 0666); err != nil {
 			fatalf("cannot write empty go_asm.h: %s", err)
 		}
-		bgrun(&wg, path, asmabis...)
-		bgwait(&wg)
+		bgrun(&g, path, asmabis...)
+		if !bgwait(&g) {
+			failInstall(dir, "")
+			return
+		}
 	}
 
 	var archive string
@@ -322,15 +379,16 @@ func runInstall(dir string, ch chan struct{}) {
 	}
 
 	compile = append(compile, gofiles...)
-	var wg sync.WaitGroup
+	var g jobGroup
 	// We use bgrun and immediately wait for it instead of calling run() synchronously.
-	// This executes all jobs through the bgwork channel and allows the process
-	// to exit cleanly in case an error occurs.
-	bgrun(&wg, path, compile...)
-	bgwait(&wg)
+	// This counts the job against the -p limit and allows the process
+	// to exit cleanly, or to keep going with -k, in case an error occurs.
+	bgrun(&g, path, compile...)
+	if !bgwait(&g) {
+		failInstall(dir, "")
+		return
+	}
 
-	_logret()
-	return
 	// Compile the files.
 	for _, p := range sfiles {
 		// Assembly file for a Go package.
@@ -342,23 +400,30 @@ func runInstall(dir string, ch chan struct{}) {
 		// Change the last character of the output file (which was c or s).
 		b = b[:len(b)-1] + "o"
 		compile = append(compile, "-o", b, p)
-		bgrun(&wg, path, compile...)
+		bgrun(&g, path, compile...)
 
 		link = append(link, b)
 		if doclean {
 			clean = append(clean, b)
 		}
 	}
-	bgwait(&wg)
+	if !bgwait(&g) {
+		failInstall(dir, "")
+		return
+	}
 
 	if ispackcmd {
 		xremove(link[targ])
 		dopack(link[targ], archive, link[targ+1:])
-		return
+	} else {
+		// Remove target before writing it.
+		xremove(link[targ])
+		bgrun(&g, "", link...)
+		if !bgwait(&g) {
+			failInstall(dir, "")
+			return
+		}
 	}
 
-	// Remove target before writing it.
-	xremove(link[targ])
-	bgrun(&wg, "", link...)
-	bgwait(&wg)
+	writeManifest(dir, link[targ], manifest)
 }
@@ -4,43 +4,61 @@ import (
 	"encoding/json"
 	"flag"
 	"os"
-	"sort"
 	"strings"
 )
 
 // cmdlist lists all supported platforms.
 func _cmdlist() {
 	jsonFlag := flag.Bool("json", false, "produce JSON output")
+	incompleteFlag := flag.Bool("incomplete", false, "include incomplete ports")
 	xflagparse(0)
 
-	var plats []string
-	for p := range cgoEnabled {
-		if incomplete[p] {
+	var plats []*platform
+	for i := range platforms {
+		p := &platforms[i]
+		if p.flags&platIncomplete != 0 && !*incompleteFlag {
 			continue
 		}
 		plats = append(plats, p)
 	}
-	sort.Strings(plats)
 
 	if !*jsonFlag {
 		for _, p := range plats {
-			xprintf("%s\n", p)
+			xprintf("%s\n", p.name)
 		}
 		return
 	}
 
 	type jsonResult struct {
-		GOOS         string
-		GOARCH       string
-		CgoSupported bool
+		GOOS          string
+		GOARCH        string
+		CgoSupported  bool
+		FirstClass    bool
+		Incomplete    bool
+		RaceSupported bool
+		MSanSupported bool
+		BuildModes    []string
+		PtrSize       int
+		BigEndian     bool
 	}
 	var results []jsonResult
 	for _, p := range plats {
-		fields := strings.Split(p, "/")
+		fields := strings.Split(p.name, "/")
+		arch, ok := archs[fields[1]]
+		if !ok {
+			fatalf("internal error: no architecture information for %s", p.name)
+		}
 		results = append(results, jsonResult{
-			GOOS:         fields[0],
-			GOARCH:       fields[1],
-			CgoSupported: cgoEnabled[p]})
+			GOOS:          fields[0],
+			GOARCH:        fields[1],
+			CgoSupported:  p.flags&platCgo != 0,
+			FirstClass:    p.flags&platFirstClass != 0,
+			Incomplete:    p.flags&platIncomplete != 0,
+			RaceSupported: p.flags&platRace != 0,
+			MSanSupported: p.flags&platMSan != 0,
+			BuildModes:    append([]string{"archive", "exe"}, strings.Fields(p.buildmodes)...),
+			PtrSize:       arch.ptrSize,
+			BigEndian:     arch.bigEndian})
 	}
 	out, err := json.MarshalIndent(results, "", "\t")
 	if err != nil {
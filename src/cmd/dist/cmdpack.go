@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// The pack command inspects the Go archives that dist and the
+// go command write, to debug the packing of assembly objects.
+//
+//	go tool dist pack list file.a
+//	go tool dist pack [-C dir] extract file.a [name...]
+//
+// list prints the kind, size and name of each member.
+// extract writes the named members, or all of them, to files
+// of the same name in dir, or the current directory.
+func _cmdpack() {
+	dir := flag.String("C", ".", "extract into dir")
+	xflagparse(-1)
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+	}
+	members, err := readArchive(flag.Arg(1))
+	if err != nil {
+		fatalf("pack: %v", err)
+	}
+	names := flag.Args()[2:]
+
+	switch flag.Arg(0) {
+	default:
+		flag.Usage()
+
+	case "list":
+		if len(names) > 0 {
+			flag.Usage()
+		}
+		for _, m := range members {
+			if vflag > 0 {
+				xprintf("%-6s %v %4d/%-4d %8d %s\n", m.kind(), m.mode, m.uid, m.gid, len(m.data), m.name)
+			} else {
+				xprintf("%-6s %8d %s\n", m.kind(), len(m.data), m.name)
+			}
+		}
+
+	case "extract":
+		want := make(map[string]bool)
+		for _, name := range names {
+			want[name] = true
+		}
+		xmkdirall(*dir)
+		for _, m := range members {
+			if len(want) > 0 && !want[m.name] {
+				continue
+			}
+			delete(want, m.name)
+			// Member names come from the archive; do not let
+			// them write outside dir.
+			if m.name == "." || m.name == ".." || strings.ContainsAny(m.name, `/\:`) {
+				fatalf("pack: refusing to extract member %q", m.name)
+			}
+			writefile(string(m.data), pathf("%s/%s", *dir, m.name), 0)
+			if vflag > 0 {
+				xprintf("%s\n", m.name)
+			}
+		}
+		for _, name := range names {
+			if want[name] {
+				errprintf("pack: no member %s in %s\n", name, flag.Arg(1))
+				xexit(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cmdreport summarizes a build event log written with -eventlog:
+// the time spent in each phase, the critical path through the
+// commands that were run, and the slowest of those commands.
+func _cmdreport() {
+	top := flag.Int("n", 10, "number of slowest commands to print")
+	xflagparse(1)
+
+	file := eventLogName
+	if flag.NArg() > 0 {
+		file = flag.Arg(0)
+	}
+	if file == "" {
+		fatalf("no event log; name one on the command line or set $GOBUILDEVENTLOG")
+	}
+	events := readEventLog(file)
+	if len(events) == 0 {
+		fatalf("%s: no events", file)
+	}
+
+	var runs []*buildEvent
+	first, last := events[0].Start, events[0].End
+	for _, ev := range events {
+		if ev.Op == "run" {
+			runs = append(runs, ev)
+		}
+		if ev.Start.Before(first) {
+			first = ev.Start
+		}
+		if ev.End.After(last) {
+			last = ev.End
+		}
+	}
+	xprintf("Total: %v (%d commands)\n", last.Sub(first), len(runs))
+
+	if phases := phaseTimes(events); len(phases) > 0 {
+		xprintf("\nPhases:\n")
+		for _, p := range phases {
+			xprintf("\t%10v  %s\n", p.d, p.name)
+		}
+	}
+
+	if len(runs) == 0 {
+		return
+	}
+
+	path := criticalPath(runs)
+	var busy time.Duration
+	for _, ev := range path {
+		busy += ev.End.Sub(ev.Start)
+	}
+	xprintf("\nCritical path: %v in %d commands, of %v:\n", busy, len(path), path[len(path)-1].End.Sub(path[0].Start))
+	for _, ev := range path {
+		xprintf("\t%10v  %s\n", ev.End.Sub(ev.Start), describeCmd(ev))
+	}
+
+	sort.Stable(bySlowest(runs))
+	if len(runs) > *top {
+		runs = runs[:*top]
+	}
+	xprintf("\nSlowest commands:\n")
+	for _, ev := range runs {
+		xprintf("\t%10v  %s\n", ev.End.Sub(ev.Start), describeCmd(ev))
+	}
+}
+
+// readEventLog returns the events recorded in file.
+func readEventLog(file string) []*buildEvent {
+	f, err := os.Open(file)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer f.Close()
+	var events []*buildEvent
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) > 0 {
+			ev := new(buildEvent)
+			if err := json.Unmarshal(b, ev); err != nil {
+				fatalf("%s:%d: %v", file, line, err)
+			}
+			events = append(events, ev)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatalf("reading %s: %v", file, err)
+		}
+	}
+	return events
+}
+
+// bySlowest sorts command events by decreasing duration.
+type bySlowest []*buildEvent
+
+func (x bySlowest) Len() int      { return len(x) }
+func (x bySlowest) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x bySlowest) Less(i, j int) bool {
+	return x[i].End.Sub(x[i].Start) > x[j].End.Sub(x[j].Start)
+}
+
+type phaseTime struct {
+	name string
+	d    time.Duration
+}
+
+// phaseTimes returns the duration of each phase marked in events.
+// A "build" marker lasts until the next build or end marker;
+// start and end markers with the same name delimit a phase.
+func phaseTimes(events []*buildEvent) []phaseTime {
+	var phases []phaseTime
+	started := map[string]time.Time{}
+	var build *buildEvent
+	endBuild := func(t time.Time) {
+		if build != nil {
+			phases = append(phases, phaseTime{build.Name, t.Sub(build.Start)})
+			build = nil
+		}
+	}
+	for _, ev := range events {
+		switch ev.Op {
+		case "build":
+			endBuild(ev.Start)
+			build = ev
+		case "start":
+			started[ev.Name] = ev.Start
+		case "end":
+			endBuild(ev.Start)
+			if t, ok := started[ev.Name]; ok {
+				phases = append(phases, phaseTime{ev.Name, ev.Start.Sub(t)})
+				delete(started, ev.Name)
+			}
+		}
+	}
+	return phases
+}
+
+// criticalPath returns the chain of commands that determined how long
+// the build took: starting from the command that finished last, it
+// repeatedly steps back to the latest command that finished before
+// the current one started. The result is in chronological order.
+func criticalPath(runs []*buildEvent) []*buildEvent {
+	cur := runs[0]
+	for _, ev := range runs {
+		if ev.End.After(cur.End) {
+			cur = ev
+		}
+	}
+	path := []*buildEvent{cur}
+	for {
+		var prev *buildEvent
+		for _, ev := range runs {
+			if ev.End.After(cur.Start) || !ev.Start.Before(cur.Start) {
+				continue
+			}
+			if prev == nil || ev.End.After(prev.End) {
+				prev = ev
+			}
+		}
+		if prev == nil {
+			break
+		}
+		path = append(path, prev)
+		cur = prev
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// describeCmd returns a short description of the command run by ev.
+func describeCmd(ev *buildEvent) string {
+	if len(ev.Cmd) == 0 {
+		return "(no command)"
+	}
+	s := filepath.Base(ev.Cmd[0]) + " " + strings.Join(ev.Cmd[1:], " ")
+	if len(s) > 100 {
+		s = s[:97] + "..."
+	}
+	if ev.Exit != 0 {
+		s += " [failed]"
+	}
+	return s
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -28,12 +29,18 @@ func _cmdtest() {
 
 	var t tester
 	var noRebuild bool
+	var shard, shardTimes string
 	flag.BoolVar(&t.listMode, "list", false, "list available tests")
 	flag.BoolVar(&t.rebuild, "rebuild", false, "rebuild everything first")
 	flag.BoolVar(&noRebuild, "no-rebuild", false, "overrides -rebuild (historical dreg)")
 	flag.BoolVar(&t.keepGoing, "k", false, "keep going even when error occurred")
 	flag.BoolVar(&t.race, "race", false, "run in race builder mode (different set of tests)")
+	flag.BoolVar(&t.json, "json", false, "report results as JSON events, like go test -json")
 	flag.BoolVar(&t.compileOnly, "compile-only", false, "compile tests, but don't run them. This is for some builders. Not all dist tests respect this flag, but most do.")
+	flag.StringVar(&shard, "shard", "", "run only shard i/n (0 <= i < n) of the tests")
+	flag.StringVar(&shardTimes, "shard-times", "", "balance shards using the test durations recorded in this file")
+	flag.StringVar(&t.historyFile, "history", pathf("%s/pkg/obj/dist-test-times", goroot), "record test durations in this file; empty means none")
+	flag.Float64Var(&t.slowFactor, "slow", 2, "warn about tests taking more than this factor times their recorded duration; 0 means never")
 	flag.StringVar(&t.banner, "banner", "##### ", "banner prefix; blank means no section banners")
 	flag.StringVar(&t.runRxStr, "run", os.Getenv("GOTESTONLY"),
 		"run only those tests matching the regular expression; empty means to run all. "+
@@ -42,39 +49,21 @@ func _cmdtest() {
 	if noRebuild {
 		t.rebuild = false
 	}
-	t.run()
-}
-
-func timelog(op, name string) {
-	if !timeLogEnabled {
-		return
-	}
-	timeLogMu.Lock()
-	defer timeLogMu.Unlock()
-	if timeLogFile == nil {
-		f, err := os.OpenFile(os.Getenv("GOBUILDTIMELOGFILE"), os.O_RDWR|os.O_APPEND, 0666)
+	if shard != "" {
+		var err error
+		t.shard, t.shards, err = parseShard(shard)
 		if err != nil {
-			log.Fatal(err)
-		}
-		buf := make([]byte, 100)
-		n, _ := f.Read(buf)
-		s := string(buf[:n])
-		if i := strings.Index(s, "\n"); i >= 0 {
-			s = s[:i]
+			fatalf("%v", err)
 		}
-		i := strings.Index(s, " start")
-		if i < 0 {
-			log.Fatalf("time log %s does not begin with start line", os.Getenv("GOBULDTIMELOGFILE"))
-		}
-		t, err := time.Parse(time.UnixDate, s[:i])
+	}
+	if shardTimes != "" {
+		times, err := readTestTimes(shardTimes)
 		if err != nil {
-			log.Fatalf("cannot parse time log line %q: %v", s, err)
+			fatalf("%v", err)
 		}
-		timeLogStart = t
-		timeLogFile = f
+		t.shardTimes = times
 	}
-	t := time.Now()
-	fmt.Fprintf(timeLogFile, "%s %+.1fs %s %s\n", t.Format(time.UnixDate), t.Sub(timeLogStart).Seconds(), op, name)
+	t.run()
 }
 
 // tester executes cmdtest.
@@ -85,6 +74,7 @@ type tester struct {
 	failed      bool
 	keepGoing   bool
 	compileOnly bool // just try to compile all tests, but no need to run
+	json        bool // report results as JSON events; see testjson.go
 	runRxStr    string
 	runRx       *regexp.Regexp
 	runRxWant   bool     // want runRx to match (true) or not match (false)
@@ -99,16 +89,32 @@ type tester struct {
 	tests        []distTest
 	timeoutScale int
 
+	shard      int                // with -shard, this worker's shard
+	shards     int                // with -shard, number of shards
+	shardTimes map[string]float64 // recorded test durations, for balancing shards
+	shardOf    map[string]int     // test name -> shard
+
+	historyFile string             // file recording test durations
+	slowFactor  float64            // warn about tests this much slower than before
+	history     map[string]float64 // recorded test durations, in seconds
+	newTimes    map[string]float64 // durations measured by this run
+
 	worklist []*work
+	curTest  *distTest   // test whose function is running
+	started  []*distTest // tests started so far, in order
+	start    time.Time   // when the tests started
+	jsonOut  io.Writer   // where -json events go
 }
 
 type work struct {
-	dt    *distTest
-	cmd   *exec.Cmd
-	start chan bool
-	out   []byte
-	err   error
-	end   chan bool
+	dt      *distTest
+	cmd     *exec.Cmd
+	start   chan bool
+	out     []byte
+	err     error
+	skipped bool
+	elapsed time.Duration // time spent running cmd
+	end     chan bool
 }
 
 // A distTest is a test run by dist test.
@@ -117,10 +123,21 @@ type distTest struct {
 	name    string // unique test name; may be filtered with -run flag
 	heading string // group section; this header is printed before the test is run.
 	fn      func(*distTest) error
+
+	// Progress of the test, maintained while it runs.
+	start    time.Time     // when the test started
+	elapsed  time.Duration // time spent in fn and in its queued commands
+	pending  int           // commands queued by addCmd that have not finished
+	fnDone   bool          // fn has returned
+	failed   bool          // fn or one of its commands failed
+	skipped  bool          // a command was skipped due to an earlier error
+	reported bool          // result has been reported
+	out      *testOutput   // with -json, output of the test
 }
 
 func (t *tester) run() {
-	timelog("start", "dist test")
+	logPhase("start", "dist test")
+	t.start = time.Now()
 
 	var exeSuffix string
 	if goos == "windows" {
@@ -141,6 +158,15 @@ func (t *tester) run() {
 
 	t.runNames = flag.Args()
 
+	if t.json {
+		// Events go to the real standard output. Everything else
+		// dist prints, including the output of rebuilding the
+		// toolchain, goes to standard error.
+		t.jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+		t.banner = ""
+	}
+
 	if t.hasBash() {
 		if _, err := exec.LookPath("time"); err == nil {
 			t.haveTime = true
@@ -201,8 +227,20 @@ func (t *tester) run() {
 	}
 
 	t.registerTests()
+	t.loadHistory()
+	if t.shards > 1 {
+		t.assignShards(t.shardTimes)
+		// The first go_test (or go_test_bench) test to run
+		// tests all matching packages; restrict those to this shard.
+		stdMatches = filter(stdMatches, func(pkg string) bool { return t.inShard("go_test:" + pkg) })
+		benchMatches = filter(benchMatches, func(pkg string) bool { return t.inShard("go_test_bench:" + pkg) })
+	}
 	if t.listMode {
 		for _, tt := range t.tests {
+			if t.shards > 1 {
+				fmt.Printf("%d/%d\t%s\n", t.shardOf[tt.name], t.shards, tt.name)
+				continue
+			}
 			fmt.Println(tt.name)
 		}
 		return
@@ -229,18 +267,41 @@ func (t *tester) run() {
 			continue
 		}
 		dt := dt // dt used in background after this iteration
-		if err := dt.fn(&dt); err != nil {
+		t.curTest = &dt
+		t.testStarted(&dt)
+		start := time.Now()
+		err := dt.fn(&dt)
+		dt.elapsed += time.Since(start)
+		t.curTest = nil
+		dt.fnDone = true
+		if err != nil {
+			dt.failed = true
+			if t.json {
+				fmt.Fprintf(t.stdout(&dt), "%v\n", err)
+			}
+		}
+		t.testMaybeDone(&dt)
+		if err != nil {
 			t.runPending(&dt) // in case that hasn't been done yet
 			t.failed = true
 			if t.keepGoing {
 				log.Printf("Failed: %v", err)
 			} else {
+				t.testsAborted()
 				log.Fatalf("Failed: %v", err)
 			}
 		}
 	}
 	t.runPending(nil)
-	timelog("end", "dist test")
+	logPhase("end", "dist test")
+	t.saveHistory()
+	if t.json {
+		action := "pass"
+		if t.failed || incomplete[goos+"/"+goarch] {
+			action = "fail"
+		}
+		t.runDone(action)
+	}
 	if t.failed {
 		fmt.Println("\nFAILED")
 		os.Exit(1)
@@ -255,6 +316,9 @@ func (t *tester) run() {
 }
 
 func (t *tester) shouldRunTest(name string) bool {
+	if !t.inShard(name) {
+		return false
+	}
 	if t.runRx != nil {
 		return t.runRx.MatchString(name) == t.runRxWant
 	}
@@ -336,8 +400,8 @@ func (t *tester) registerStdTest(pkg string) {
 				return nil
 			}
 			t.runPending(dt)
-			timelog("start", dt.name)
-			defer timelog("end", dt.name)
+			logPhase("start", dt.name)
+			defer logPhase("end", dt.name)
 			ranGoTest = true
 
 			timeoutSec := 180
@@ -364,8 +428,8 @@ func (t *tester) registerStdTest(pkg string) {
 			}
 			args = append(args, stdMatches...)
 			cmd := exec.Command("go", args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+			cmd.Stdout = t.stdout(dt)
+			cmd.Stderr = t.stderr(dt)
 			return cmd.Run()
 		},
 	})
@@ -384,8 +448,8 @@ func (t *tester) registerRaceBenchTest(pkg string) {
 				return nil
 			}
 			t.runPending(dt)
-			timelog("start", dt.name)
-			defer timelog("end", dt.name)
+			logPhase("start", dt.name)
+			defer logPhase("end", dt.name)
 			ranGoBench = true
 			args := []string{
 				"test",
@@ -401,8 +465,8 @@ func (t *tester) registerRaceBenchTest(pkg string) {
 			}
 			args = append(args, benchMatches...)
 			cmd := exec.Command("go", args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+			cmd.Stdout = t.stdout(dt)
+			cmd.Stderr = t.stderr(dt)
 			return cmd.Run()
 		},
 	})
@@ -509,10 +573,10 @@ func (t *tester) registerTests() {
 			heading: "cmd/go terminal test",
 			fn: func(dt *distTest) error {
 				t.runPending(dt)
-				timelog("start", dt.name)
-				defer timelog("end", dt.name)
+				logPhase("start", dt.name)
+				defer logPhase("end", dt.name)
 				if !stdOutErrAreTerminals() {
-					fmt.Println("skipping terminal test; stdout/stderr not terminals")
+					fmt.Fprintln(t.stdout(dt), "skipping terminal test; stdout/stderr not terminals")
 					return nil
 				}
 				cmd := exec.Command("go", "test")
@@ -535,8 +599,8 @@ func (t *tester) registerTests() {
 			heading: "moved GOROOT",
 			fn: func(dt *distTest) error {
 				t.runPending(dt)
-				timelog("start", dt.name)
-				defer timelog("end", dt.name)
+				logPhase("start", dt.name)
+				defer logPhase("end", dt.name)
 				moved := goroot + "-moved"
 				if err := os.Rename(goroot, moved); err != nil {
 					if goos == "windows" {
@@ -555,8 +619,8 @@ func (t *tester) registerTests() {
 
 				// Run `go test fmt` in the moved GOROOT.
 				cmd := exec.Command(filepath.Join(moved, "bin", "go"), "test", "fmt")
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
+				cmd.Stdout = t.stdout(dt)
+				cmd.Stderr = t.stderr(dt)
 				// Don't set GOROOT in the environment.
 				for _, e := range os.Environ() {
 					if !strings.HasPrefix(e, "GOROOT=") && !strings.HasPrefix(e, "GOCACHE=") {
@@ -818,8 +882,8 @@ func (t *tester) registerTest1(seq bool, name, dirBanner string, cmdline ...inte
 		fn: func(dt *distTest) error {
 			if seq {
 				t.runPending(dt)
-				timelog("start", name)
-				defer timelog("end", name)
+				logPhase("start", name)
+				defer logPhase("end", name)
 				return t.dirCmd(filepath.Join(goroot, "src", dirBanner), bin, args).Run()
 			}
 			t.addCmd(dt, filepath.Join(goroot, "src", dirBanner), bin, args)
@@ -851,6 +915,10 @@ func (t *tester) dirCmd(dir string, cmdline ...interface{}) *exec.Cmd {
 	cmd := t.bgDirCmd(dir, bin, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if dt := t.curTest; dt != nil {
+		cmd.Stdout = t.stdout(dt)
+		cmd.Stderr = t.stderr(dt)
+	}
 	if vflag > 1 {
 		errprintf("%s\n", strings.Join(cmd.Args, " "))
 	}
@@ -908,6 +976,7 @@ func (t *tester) addCmd(dt *distTest, dir string, cmdline ...interface{}) *exec.
 		cmd: t.bgDirCmd(dir, bin, args...),
 	}
 	t.worklist = append(t.worklist, w)
+	dt.pending++
 	return w.cmd
 }
 
@@ -968,60 +1037,16 @@ func (t *tester) internalLink() bool {
 }
 
 func (t *tester) supportedBuildmode(mode string) bool {
-	pair := goos + "-" + goarch
 	switch mode {
 	case "c-archive":
 		if !t.extLink() {
 			return false
 		}
-		switch pair {
-		case "darwin-386", "darwin-amd64", "darwin-arm", "darwin-arm64",
-			"linux-amd64", "linux-386", "linux-ppc64le", "linux-s390x",
-			"freebsd-amd64",
-			"windows-amd64", "windows-386":
-			return true
-		}
-		return false
-	case "c-shared":
-		switch pair {
-		case "linux-386", "linux-amd64", "linux-arm", "linux-arm64", "linux-ppc64le", "linux-s390x",
-			"darwin-amd64", "darwin-386",
-			"freebsd-amd64",
-			"android-arm", "android-arm64", "android-386",
-			"windows-amd64", "windows-386":
-			return true
-		}
-		return false
-	case "shared":
-		switch pair {
-		case "linux-386", "linux-amd64", "linux-arm", "linux-arm64", "linux-ppc64le", "linux-s390x":
-			return true
-		}
-		return false
-	case "plugin":
-		// linux-arm64 is missing because it causes the external linker
-		// to crash, see https://golang.org/issue/17138
-		switch pair {
-		case "linux-386", "linux-amd64", "linux-arm", "linux-s390x", "linux-ppc64le":
-			return true
-		case "darwin-amd64":
-			return true
-		}
-		return false
-	case "pie":
-		switch pair {
-		case "linux-386", "linux-amd64", "linux-arm", "linux-arm64", "linux-ppc64le", "linux-s390x",
-			"android-amd64", "android-arm", "android-arm64", "android-386":
-			return true
-		case "darwin-amd64":
-			return true
-		}
-		return false
-
+	case "c-shared", "shared", "plugin", "pie":
 	default:
 		log.Fatalf("internal error: unknown buildmode %s", mode)
-		return false
 	}
+	return platformBuildmode(goos, goarch, mode)
 }
 
 func (t *tester) registerHostTest(name, heading, dir, pkg string) {
@@ -1030,8 +1055,8 @@ func (t *tester) registerHostTest(name, heading, dir, pkg string) {
 		heading: heading,
 		fn: func(dt *distTest) error {
 			t.runPending(dt)
-			timelog("start", name)
-			defer timelog("end", name)
+			logPhase("start", name)
+			defer logPhase("end", name)
 			return t.runHostTest(dir, pkg)
 		},
 	})
@@ -1092,7 +1117,7 @@ func (t *tester) cgoTest(dt *distTest) error {
 				compilerEnvLookup(defaultcc, goos, goarch), "-xc", "-o", "/dev/null", "-static", "-")
 			cmd.Stdin = strings.NewReader("int main() {}")
 			if err := cmd.Run(); err != nil {
-				fmt.Println("No support for static linking found (lacks libc.a?), skip cgo static linking test.")
+				fmt.Fprintln(t.stdout(dt), "No support for static linking found (lacks libc.a?), skip cgo static linking test.")
 			} else {
 				if goos != "android" {
 					t.addCmd(dt, "misc/cgo/testtls", t.goTest(), "-ldflags", `-linkmode=external -extldflags "-static -pthread"`)
@@ -1129,20 +1154,30 @@ func (t *tester) cgoTest(dt *distTest) error {
 // for needing the earlier tests to be done.
 func (t *tester) runPending(nextTest *distTest) {
 	checkNotStale("go", "std")
+	if dt := t.curTest; dt != nil {
+		// The commands run here belong to earlier tests,
+		// so do not count them in the test that called us.
+		start := time.Now()
+		defer func() { dt.elapsed -= time.Since(start) }()
+	}
 	worklist := t.worklist
 	t.worklist = nil
+	t.sortWorklist(worklist)
 	for _, w := range worklist {
 		w.start = make(chan bool)
 		w.end = make(chan bool)
 		go func(w *work) {
 			if !<-w.start {
-				timelog("skip", w.dt.name)
+				logPhase("skip", w.dt.name)
+				w.skipped = true
 				w.out = []byte(fmt.Sprintf("skipped due to earlier error\n"))
 			} else {
-				timelog("start", w.dt.name)
+				logPhase("start", w.dt.name)
+				start := time.Now()
 				w.out, w.err = w.cmd.CombinedOutput()
+				w.elapsed = time.Since(start)
 			}
-			timelog("end", w.dt.name)
+			logPhase("end", w.dt.name)
 			w.end <- true
 		}(w)
 	}
@@ -1176,14 +1211,22 @@ func (t *tester) runPending(nextTest *distTest) {
 		//println("wait", ended)
 		ended++
 		<-w.end
-		os.Stdout.Write(w.out)
+		t.stdout(dt).Write(w.out)
 		if w.err != nil {
 			log.Printf("Failed: %v", w.err)
 			t.failed = true
+			dt.failed = true
 		}
+		if w.skipped {
+			dt.skipped = true
+		}
+		dt.elapsed += w.elapsed
+		dt.pending--
+		t.testMaybeDone(dt)
 		checkNotStale("go", "std")
 	}
 	if t.failed && !t.keepGoing {
+		t.testsAborted()
 		log.Fatal("FAILED")
 	}
 
@@ -1217,8 +1260,8 @@ func (t *tester) cgoTestSOSupported() bool {
 func (t *tester) cgoTestSO(dt *distTest, testpath string) error {
 	t.runPending(dt)
 
-	timelog("start", dt.name)
-	defer timelog("end", dt.name)
+	logPhase("start", dt.name)
+	defer logPhase("end", dt.name)
 
 	dir := filepath.Join(goroot, testpath)
 
@@ -1500,27 +1543,15 @@ func (t *tester) packageHasBenchmarks(pkg string) bool {
 	return false
 }
 
-// raceDetectorSupported is a copy of the function
+// raceDetectorSupported reports whether the race detector
+// is supported on goos/goarch. It answers the same as the function
 // cmd/internal/sys.RaceDetectorSupported, which can't be used here
 // because cmd/dist has to be buildable by Go 1.4.
 func raceDetectorSupported(goos, goarch string) bool {
-	switch goos {
-	case "linux":
-		return goarch == "amd64" || goarch == "ppc64le" || goarch == "arm64"
-	case "darwin", "freebsd", "netbsd", "windows":
-		return goarch == "amd64"
-	default:
-		return false
-	}
+	return platformHas(goos, goarch, platRace)
 }
 
-// mSanSupported is a copy of the function cmd/internal/sys.MSanSupported,
-// which can't be used here because cmd/dist has to be buildable by Go 1.4.
+// mSanSupported is the equivalent of cmd/internal/sys.MSanSupported.
 func mSanSupported(goos, goarch string) bool {
-	switch goos {
-	case "linux":
-		return goarch == "amd64" || goarch == "arm64"
-	default:
-		return false
-	}
+	return platformHas(goos, goarch, platMSan)
 }
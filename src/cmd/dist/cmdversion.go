@@ -1,12 +1,54 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
+// cmdversion prints the Go version, after first recording it
+// in $GOROOT/VERSION (or VERSION.cache) if asked to with -set.
+func _cmdversion() {
+	set := flag.String("set", "", "record this version string in $GOROOT/VERSION")
+	cache := flag.Bool("cache", false, "with -set, write VERSION.cache instead, which clean removes")
+	xflagparse(0)
+
+	if *cache && *set == "" {
+		fatalf("-cache requires -set")
+	}
+	if *set != "" {
+		if !versionFormatRE.MatchString(*set) {
+			fatalf("invalid version %q: want go1.N, go1.N.M, go1.NbetaM, go1.NrcM, or devel followed by a description", *set)
+		}
+		if *cache {
+			// findgoversion prefers a non-empty VERSION file,
+			// so the cache would never be read.
+			if file := pathf("%s/VERSION", goroot); isfile(file) && chomp(readfile(file)) != "" {
+				fatalf("cannot use -cache: %s exists and takes precedence over VERSION.cache", file)
+			}
+			writefile(*set, pathf("%s/VERSION.cache", goroot), 0)
+		} else {
+			writefile(*set, pathf("%s/VERSION", goroot), 0)
+			// The cache would otherwise record a stale version.
+			xremove(pathf("%s/VERSION.cache", goroot))
+		}
+	}
+	xprintf("%s\n", findgoversion())
+}
+
+// versionFormatRE matches the version strings accepted by version -set.
+var versionFormatRE = regexp.MustCompile(`^(go1(\.(0|[1-9][0-9]*)){0,2}((beta|rc)[1-9][0-9]*)?|devel( [[:print:]]+)?)$`)
+
+// haveGit reports whether the git command is available.
+func haveGit() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
 func branchtag(branch string) (tag string, precise bool) {
 	log := run(goroot, CheckExit, "git", "log", "--decorate=full", "--format=format:%d", "master.."+branch)
 	tag = branch
@@ -37,6 +79,9 @@ func branchtag(branch string) (tag string, precise bool) {
 
 // isGitRepo reports whether the working directory is inside a Git repository.
 func isGitRepo() bool {
+	if !haveGit() {
+		return gitDir(goroot) != ""
+	}
 	// NB: simply checking the exit code of `git rev-parse --git-dir` would
 	// suffice here, but that requires deviating from the infrastructure
 	// provided by `run`.
@@ -52,7 +97,6 @@ func findgoversion() string {
 	// The $GOROOT/VERSION file takes priority, for distributions
 	// without the source repo.
 	path := pathf("%s/VERSION", goroot)
-	_p(1, "versionfile:", path)
 	if isfile(path) {
 		b := chomp(readfile(path))
 		// Commands such as "dist version > VERSION" will cause
@@ -98,6 +142,13 @@ func findgoversion() string {
 		fatalf("FAILED: not a Git repo; must put a VERSION file in $GOROOT")
 	}
 
+	// Without the git command, read the repository directly.
+	if !haveGit() {
+		tag := gitversion()
+		writefile(tag, path, 0)
+		return tag
+	}
+
 	// Otherwise, use Git.
 	// What is the current branch?
 	branch := chomp(run(goroot, CheckExit, "git", "rev-parse", "--abbrev-ref", "HEAD"))
@@ -114,7 +165,13 @@ func findgoversion() string {
 
 	if !precise {
 		// Tag does not point at HEAD; add hash and date to version.
-		tag += chomp(run(goroot, CheckExit, "git", "log", "-n", "1", "--format=format: +%h %cd", "HEAD"))
+		// $SOURCE_DATE_EPOCH, if set, replaces the commit date.
+		if t, ok := sourceDate(); ok {
+			tag += chomp(run(goroot, CheckExit, "git", "log", "-n", "1", "--format=format: +%h", "HEAD"))
+			tag += " " + t.Format(gitDateLayout)
+		} else {
+			tag += chomp(run(goroot, CheckExit, "git", "log", "-n", "1", "--format=format: +%h %cd", "HEAD"))
+		}
 	}
 
 	// Cache version.
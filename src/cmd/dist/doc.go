@@ -10,10 +10,21 @@
 // The commands are:
 //   banner         print installation banner
 //   bootstrap      rebuild everything
-//   clean          deletes all built files
-//   env [-p]       print environment (-p: include $PATH)
+//   clean [-n]     deletes generated files (-n: list them; see -h for selectors)
+//   distpack [-target=os/arch]
+//                  build a distribution archive for os/arch
+//   doctor [-json] check the host environment before building
+//   env [-p] [-json] [-fish] [-powershell] [-why]
+//                  print environment (-p: include $PATH;
+//                  -why: say where values came from)
+//   generate [-check]
+//                  regenerate the z files (-check: report stale ones)
+//   graph [dirs]   print the package dependency graph used by install
 //   install [dir]  install individual directory
 //   list [-json]   list all supported platforms
+//   pack list|extract file.a
+//                  list or extract the members of a Go archive
+//   report [file]  summarize a build event log
 //   test [-h]      run Go test(s)
-//   version        print Go version
+//   version [-set] print Go version (-set: record it in VERSION)
 package main
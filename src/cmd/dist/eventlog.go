@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+/*
+ * Structured build event log.
+ *
+ * When enabled with -eventlog=file (or $GOBUILDEVENTLOG),
+ * dist appends one JSON object per line to file: one for
+ * every command started by run or bgrun, and one for every
+ * phase marker (start, end, build, skip) of bootstrap and test.
+ * The report command summarizes such a log.
+ */
+
+// A buildEvent is one line of the build event log.
+type buildEvent struct {
+	Op         string    // "run" for a command; otherwise a phase marker
+	Name       string    `json:",omitempty"` // phase name, for phase markers
+	Start      time.Time // when the command started, or the time of the marker
+	End        time.Time // when the command exited; Start for markers
+	Cmd        []string  `json:",omitempty"`
+	Dir        string    `json:",omitempty"`
+	Exit       int       // exit status; -1 if the command could not be run
+	Stdout     int64     // bytes written to standard output
+	Stderr     int64     // bytes written to standard error
+	Background bool      `json:",omitempty"` // run by bgrun
+}
+
+var (
+	eventLogName string // set by -eventlog
+	eventLogMu   sync.Mutex
+	eventLogFile *os.File
+)
+
+// openEventLog opens the event log named by -eventlog, if any.
+// It is called by xflagparse once the flags are known.
+func openEventLog() {
+	if eventLogName == "" || eventLogFile != nil {
+		return
+	}
+	f, err := os.OpenFile(eventLogName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		fatalf("opening event log: %v", err)
+	}
+	eventLogFile = f
+	xatexit(func() { f.Close() })
+}
+
+// logEvent appends ev to the event log, if it is enabled.
+func logEvent(ev *buildEvent) {
+	eventLogMu.Lock()
+	defer eventLogMu.Unlock()
+	if eventLogFile == nil {
+		return
+	}
+	js, err := json.Marshal(ev)
+	if err != nil {
+		fatalf("json marshal error: %v", err)
+	}
+	js = append(js, '\n')
+	eventLogFile.Write(js)
+}
+
+// logPhase records that the build is at the given point (op) of phase name.
+// The ops used are start and end for whole commands and tests,
+// build for a new step of bootstrap, and skip for skipped tests.
+func logPhase(op, name string) {
+	if eventLogName == "" {
+		return
+	}
+	now := time.Now()
+	logEvent(&buildEvent{Op: op, Name: name, Start: now, End: now})
+}
+
+// exitStatus returns the exit status corresponding to the error
+// returned by running a command, or -1 if it did not run at all.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(interface {
+			ExitStatus() int
+		}); ok {
+			return ws.ExitStatus()
+		}
+		return 1
+	}
+	return -1
+}
+
+// countWriter forwards writes to w, counting the bytes written.
+// Writers for a command's standard output and standard error
+// share mu, so that they may also share w.
+type countWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+	n  int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += int64(len(b))
+	return c.w.Write(b)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestBootstrapEventLog runs dist bootstrap, in a copy of the test
+// binary, with a $GOROOT that makes it fail early, and checks that
+// the event log has both its start and end markers.
+func TestBootstrapEventLog(t *testing.T) {
+	if dir := os.Getenv("GO_DIST_TEST_GOROOT"); dir != "" {
+		goroot = dir
+		os.Args = []string{"bootstrap", "-eventlog=" + filepath.Join(dir, "events")}
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		_cmdbootstrap()
+		xexit(0)
+	}
+
+	root := tempGoroot(t, map[string]string{"src/pkg/README": "old layout\n"})
+	defer os.RemoveAll(root)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBootstrapEventLog$")
+	cmd.Env = append(os.Environ(), "GO_DIST_TEST_GOROOT="+root)
+	out, err := cmd.CombinedOutput()
+	if ee, ok := err.(*exec.ExitError); !ok || exitStatus(ee) != 2 {
+		t.Fatalf("dist bootstrap: %v, want exit status 2\n%s", err, out)
+	}
+
+	if _, err := ioutil.ReadFile(filepath.Join(root, "events")); err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, ev := range readEventLog(filepath.Join(root, "events")) {
+		if ev.Name == "dist bootstrap" {
+			ops = append(ops, ev.Op)
+		}
+	}
+	if len(ops) != 2 || ops[0] != "start" || ops[1] != "end" {
+		t.Errorf("dist bootstrap markers = %v, want [start end]", ops)
+	}
+	if phases := phaseTimes(readEventLog(filepath.Join(root, "events"))); len(phases) != 1 || phases[0].name != "dist bootstrap" {
+		t.Errorf("phaseTimes = %v, want the dist bootstrap phase", phases)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Reading a Git repository without git.
+ *
+ * findgoversion prefers the git command, but tarball checkouts and
+ * sandboxes often have a .git directory and no git binary.
+ * The functions here read just enough of the repository layout
+ * to name the commit at HEAD: the HEAD file, loose refs, packed-refs,
+ * and, when the commit is stored as a loose object, its date.
+ */
+
+// gitDir returns the Git directory of the repository at root,
+// or "" if root is not the top of a Git checkout.
+// A .git file, as used by worktrees and submodules, names the directory.
+func gitDir(root string) string {
+	dir := filepath.Join(root, ".git")
+	if isdir(dir) {
+		return dir
+	}
+	if !isfile(dir) {
+		return ""
+	}
+	line := chomp(readfile(dir))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return ""
+	}
+	dir = strings.TrimSpace(line[len("gitdir: "):])
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	if !isdir(dir) {
+		return ""
+	}
+	return dir
+}
+
+// gitCommonDir returns the directory holding the refs and objects
+// shared by the worktree whose Git directory is dir.
+func gitCommonDir(dir string) string {
+	file := filepath.Join(dir, "commondir")
+	if !isfile(file) {
+		return dir
+	}
+	common := chomp(readfile(file))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+	return common
+}
+
+var gitHashRE = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// gitHead returns the branch checked out in the Git directory dir,
+// or "HEAD" if it is detached, and the hash of the commit at HEAD.
+func gitHead(dir string) (branch, hash string, err error) {
+	file := filepath.Join(dir, "HEAD")
+	if !isfile(file) {
+		return "", "", fmt.Errorf("%s does not exist", file)
+	}
+	head := chomp(readfile(file))
+	if !strings.HasPrefix(head, "ref: ") {
+		if !gitHashRE.MatchString(head) {
+			return "", "", fmt.Errorf("%s/HEAD: unrecognized content %q", dir, head)
+		}
+		return "HEAD", head, nil
+	}
+	ref := strings.TrimSpace(head[len("ref: "):])
+	hash, err = gitResolveRef(dir, ref)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), hash, nil
+}
+
+// gitResolveRef returns the hash that ref, like refs/heads/master,
+// names in the Git directory dir: from its loose ref file if there
+// is one, and otherwise from packed-refs. Symbolic refs are followed.
+func gitResolveRef(dir, ref string) (string, error) {
+	for i := 0; i < 10; i++ {
+		var data string
+		for _, d := range []string{dir, gitCommonDir(dir)} {
+			if file := filepath.Join(d, filepath.FromSlash(ref)); isfile(file) {
+				data = chomp(readfile(file))
+				break
+			}
+		}
+		if data == "" {
+			refs := gitPackedRefs(dir)
+			if hash, ok := refs[ref]; ok {
+				return hash, nil
+			}
+			return "", fmt.Errorf("cannot resolve Git ref %s", ref)
+		}
+		if !strings.HasPrefix(data, "ref: ") {
+			if !gitHashRE.MatchString(data) {
+				return "", fmt.Errorf("Git ref %s: unrecognized content %q", ref, data)
+			}
+			return data, nil
+		}
+		ref = strings.TrimSpace(data[len("ref: "):])
+	}
+	return "", fmt.Errorf("Git ref %s: too many levels of symbolic refs", ref)
+}
+
+// gitPackedRefs returns the refs listed in the packed-refs file of
+// the Git directory dir. For an annotated tag, the map also holds
+// the commit it points to, under the tag's name followed by ^{}.
+func gitPackedRefs(dir string) map[string]string {
+	refs := make(map[string]string)
+	file := filepath.Join(gitCommonDir(dir), "packed-refs")
+	if !isfile(file) {
+		return refs
+	}
+	last := ""
+	for _, line := range strings.Split(readfile(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '^' {
+			if last != "" {
+				refs[last+"^{}"] = line[1:]
+			}
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 || !gitHashRE.MatchString(f[0]) {
+			continue
+		}
+		refs[f[1]] = f[0]
+		last = f[1]
+	}
+	return refs
+}
+
+// gitTagAt returns the name of a tag in the Git directory dir that
+// points at the commit hash, or "" if there is none. When several do,
+// the one that sorts first is used, so that the answer is stable.
+func gitTagAt(dir, hash string) string {
+	var tags []string
+	for ref, h := range gitPackedRefs(dir) {
+		if h == hash && strings.HasPrefix(ref, "refs/tags/") {
+			tags = append(tags, strings.TrimSuffix(ref, "^{}"))
+		}
+	}
+	tagDir := filepath.Join(gitCommonDir(dir), "refs", "tags")
+	filepath.Walk(tagDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		h := chomp(readfile(path))
+		if h != hash {
+			// An annotated tag points at a tag object naming the commit.
+			h = gitTagTarget(dir, h)
+		}
+		if h == hash {
+			rel, _ := filepath.Rel(tagDir, path)
+			tags = append(tags, "refs/tags/"+filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(tags) == 0 {
+		return ""
+	}
+	tags = uniq(tags)
+	return strings.TrimPrefix(tags[0], "refs/tags/")
+}
+
+// gitLooseObject returns the content of the object hash if it is
+// stored as a loose object in the Git directory dir, and otherwise nil.
+// Objects in pack files are not read.
+func gitLooseObject(dir, hash string) []byte {
+	if len(hash) != 40 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gitCommonDir(dir), "objects", hash[:2], hash[2:]))
+	if err != nil {
+		return nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	obj, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	// Drop the "type size\x00" header.
+	i := bytes.IndexByte(obj, 0)
+	if i < 0 {
+		return nil
+	}
+	return obj[i+1:]
+}
+
+// gitTagTarget returns the object named by the annotated tag object hash,
+// or "" if hash is not a loose tag object.
+func gitTagTarget(dir, hash string) string {
+	obj := gitLooseObject(dir, hash)
+	if !bytes.HasPrefix(obj, []byte("object ")) {
+		return ""
+	}
+	line := obj[len("object "):]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return string(line)
+}
+
+// gitCommitTime returns the committer time of the commit hash,
+// if it is stored as a loose object in the Git directory dir.
+func gitCommitTime(dir, hash string) (time.Time, bool) {
+	for _, line := range strings.Split(string(gitLooseObject(dir, hash)), "\n") {
+		if line == "" {
+			break // end of the commit header
+		}
+		if !strings.HasPrefix(line, "committer ") {
+			continue
+		}
+		// committer Name <email> 1234567890 -0700
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			break
+		}
+		sec, err := strconv.ParseInt(f[len(f)-2], 10, 64)
+		if err != nil {
+			break
+		}
+		zone := f[len(f)-1]
+		loc := time.UTC
+		if t, err := time.Parse("-0700", zone); err == nil {
+			_, offset := t.Zone()
+			loc = time.FixedZone("", offset)
+		}
+		return time.Unix(sec, 0).In(loc), true
+	}
+	return time.Time{}, false
+}
+
+// sourceDate returns the time given by $SOURCE_DATE_EPOCH, if set.
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func sourceDate() (time.Time, bool) {
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		fatalf("invalid $SOURCE_DATE_EPOCH %q: %v", s, err)
+	}
+	return time.Unix(sec, 0).UTC(), true
+}
+
+// gitDateLayout is the layout of the dates printed by git log's %cd.
+const gitDateLayout = "Mon Jan 2 15:04:05 2006 -0700"
+
+// gitversion determines the version string for the Git checkout at
+// goroot by reading the repository directly. It is used when the git
+// command is not available, and gives the same answer as the git-based
+// code in findgoversion, except that on a release branch only a tag at
+// HEAD itself is recognized, and the date is omitted if the commit is
+// packed and $SOURCE_DATE_EPOCH is not set.
+func gitversion() string {
+	dir := gitDir(goroot)
+	branch, hash, err := gitHead(dir)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if strings.HasPrefix(branch, "release-branch.") {
+		if tag := gitTagAt(dir, hash); tag != "" {
+			return tag
+		}
+	}
+	tag := "devel +" + hash[:7]
+	if t, ok := sourceDate(); ok {
+		tag += " " + t.Format(gitDateLayout)
+	} else if t, ok := gitCommitTime(dir, hash); ok {
+		tag += " " + t.Format(gitDateLayout)
+	}
+	return tag
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	hashC = "cccccccccccccccccccccccccccccccccccccccc"
+	hashT = "dddddddddddddddddddddddddddddddddddddddd" // an annotated tag object
+)
+
+// looseObject returns the path and content of the loose object
+// hash, of the given type, holding body.
+func looseObject(hash, typ, body string) (string, string) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	fmt.Fprintf(w, "%s %d\x00%s", typ, len(body), body)
+	w.Close()
+	return ".git/objects/" + hash[:2] + "/" + hash[2:], buf.String()
+}
+
+// gitFixture returns a temporary checkout holding files, the commit
+// hashB as a loose object, and hashT, an annotated tag of hashC.
+func gitFixture(t *testing.T, files map[string]string) string {
+	commit, data := looseObject(hashB, "commit",
+		"tree "+hashA+"\n"+
+			"author Gopher <gopher@golang.org> 1500000000 +0000\n"+
+			"committer Gopher <gopher@golang.org> 1546300800 -0800\n"+
+			"\n"+
+			"committer 1 +0000 in the message is not the header\n")
+	files[commit] = data
+	tag, data := looseObject(hashT, "tag", "object "+hashC+"\ntype commit\ntag go1.12beta1\n\nmessage\n")
+	files[tag] = data
+	return tempGoroot(t, files)
+}
+
+func TestGitDir(t *testing.T) {
+	root := tempGoroot(t, map[string]string{
+		"repo/.git/HEAD":              "ref: refs/heads/master\n",
+		"worktree/.git":               "gitdir: ../repo/.git/worktrees/wt\n",
+		"repo/.git/worktrees/wt/HEAD": hashA + "\n",
+		"bad/.git":                    "not a gitdir line\n",
+		"missing/.git":                "gitdir: nowhere\n",
+		"plain/README":                "not a checkout\n",
+	})
+	defer os.RemoveAll(root)
+
+	abs := filepath.Join(root, "repo", ".git", "worktrees", "wt")
+	if err := os.MkdirAll(filepath.Join(root, "absolute"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	writefile("gitdir: "+abs+"\n", filepath.Join(root, "absolute", ".git"), 0)
+
+	tests := []struct {
+		dir, want string
+	}{
+		{"repo", filepath.Join(root, "repo", ".git")},
+		{"worktree", abs},
+		{"absolute", abs},
+		{"bad", ""},
+		{"missing", ""},
+		{"plain", ""},
+	}
+	for _, tt := range tests {
+		if got := gitDir(filepath.Join(root, tt.dir)); got != tt.want {
+			t.Errorf("gitDir(%s) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestGitHead(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        map[string]string
+		branch, hash string
+		err          string
+	}{
+		{
+			name:   "detached",
+			files:  map[string]string{".git/HEAD": hashA + "\n"},
+			branch: "HEAD",
+			hash:   hashA,
+		},
+		{
+			name: "loose ref",
+			files: map[string]string{
+				".git/HEAD":              "ref: refs/heads/master\n",
+				".git/refs/heads/master": hashA + "\n",
+				".git/packed-refs":       hashB + " refs/heads/master\n",
+			},
+			branch: "master",
+			hash:   hashA,
+		},
+		{
+			name: "packed ref",
+			files: map[string]string{
+				".git/HEAD": "ref: refs/heads/release-branch.go1.12\n",
+				".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" +
+					hashA + " refs/heads/master\n" +
+					hashB + " refs/heads/release-branch.go1.12\n",
+			},
+			branch: "release-branch.go1.12",
+			hash:   hashB,
+		},
+		{
+			name: "symbolic ref",
+			files: map[string]string{
+				".git/HEAD":               "ref: refs/heads/current\n",
+				".git/refs/heads/current": "ref: refs/heads/master\n",
+				".git/packed-refs":        hashC + " refs/heads/master\n",
+			},
+			branch: "current",
+			hash:   hashC,
+		},
+		{
+			name: "symbolic ref loop",
+			files: map[string]string{
+				".git/HEAD":         "ref: refs/heads/a\n",
+				".git/refs/heads/a": "ref: refs/heads/b\n",
+				".git/refs/heads/b": "ref: refs/heads/a\n",
+			},
+			err: "too many levels of symbolic refs",
+		},
+		{
+			name: "worktree",
+			files: map[string]string{
+				".git":                             "gitdir: main/.git/worktrees/wt\n",
+				"main/.git/HEAD":                   "ref: refs/heads/master\n",
+				"main/.git/worktrees/wt/HEAD":      "ref: refs/heads/feature\n",
+				"main/.git/worktrees/wt/commondir": "../..\n",
+				"main/.git/packed-refs":            hashB + " refs/heads/feature\n",
+			},
+			branch: "feature",
+			hash:   hashB,
+		},
+		{
+			name:  "unknown ref",
+			files: map[string]string{".git/HEAD": "ref: refs/heads/gone\n"},
+			err:   "cannot resolve Git ref refs/heads/gone",
+		},
+		{
+			name:  "bad HEAD",
+			files: map[string]string{".git/HEAD": "garbage\n"},
+			err:   "unrecognized content",
+		},
+	}
+	for _, tt := range tests {
+		root := tempGoroot(t, tt.files)
+		branch, hash, err := gitHead(gitDir(root))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: gitHead = %q, %q, %v, want error containing %q", tt.name, branch, hash, err, tt.err)
+			}
+		} else if err != nil || branch != tt.branch || hash != tt.hash {
+			t.Errorf("%s: gitHead = %q, %q, %v, want %q, %q", tt.name, branch, hash, err, tt.branch, tt.hash)
+		}
+		os.RemoveAll(root)
+	}
+}
+
+func TestGitTagAt(t *testing.T) {
+	root := gitFixture(t, map[string]string{
+		".git/HEAD": hashA + "\n",
+		".git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" +
+			hashA + " refs/tags/go1.11\n" +
+			hashT + " refs/tags/go1.12rc1\n" +
+			"^" + hashB + "\n" +
+			hashA + " refs/heads/master\n",
+		".git/refs/tags/weekly":      hashC + "\n",
+		".git/refs/tags/go1.12beta1": hashT + "\n",
+	})
+	defer os.RemoveAll(root)
+	dir := gitDir(root)
+
+	tests := []struct {
+		hash, want string
+	}{
+		{hashA, "go1.11"},      // lightweight tag in packed-refs
+		{hashB, "go1.12rc1"},   // peeled annotated tag in packed-refs
+		{hashC, "go1.12beta1"}, // loose annotated tag, sorting before weekly
+		{hashT, "go1.12beta1"}, // the tag objects themselves
+		{"eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee", ""},
+	}
+	for _, tt := range tests {
+		if got := gitTagAt(dir, tt.hash); got != tt.want {
+			t.Errorf("gitTagAt(%s) = %q, want %q", tt.hash[:7], got, tt.want)
+		}
+	}
+}
+
+func TestGitCommitTime(t *testing.T) {
+	root := gitFixture(t, map[string]string{".git/HEAD": hashB + "\n"})
+	defer os.RemoveAll(root)
+	dir := gitDir(root)
+
+	tm, ok := gitCommitTime(dir, hashB)
+	if want := "Mon Dec 31 16:00:00 2018 -0800"; !ok || tm.Format(gitDateLayout) != want {
+		t.Errorf("gitCommitTime = %v, %v, want %s", tm.Format(gitDateLayout), ok, want)
+	}
+	if _, ok := gitCommitTime(dir, hashA); ok {
+		t.Errorf("gitCommitTime of a missing object succeeded")
+	}
+	if _, ok := gitCommitTime(dir, hashT); ok {
+		t.Errorf("gitCommitTime of a tag object succeeded")
+	}
+}
+
+func TestSourceDate(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+
+	os.Setenv("SOURCE_DATE_EPOCH", "")
+	if tm, ok := sourceDate(); ok {
+		t.Errorf("sourceDate with $SOURCE_DATE_EPOCH unset = %v, want none", tm)
+	}
+	os.Setenv("SOURCE_DATE_EPOCH", "1546300800")
+	if tm, ok := sourceDate(); !ok || !tm.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) || tm.Location() != time.UTC {
+		t.Errorf("sourceDate = %v, %v, want 2019-01-01 00:00:00 UTC", tm, ok)
+	}
+}
+
+func TestGitversion(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	defer func(old string) { goroot = old }(goroot)
+
+	tests := []struct {
+		head  string
+		epoch string
+		want  string
+	}{
+		{"ref: refs/heads/release-branch.go1.12", "", "go1.12rc1"},
+		{"ref: refs/heads/master", "", "devel +bbbbbbb Mon Dec 31 16:00:00 2018 -0800"},
+		{"ref: refs/heads/master", "0", "devel +bbbbbbb Thu Jan 1 00:00:00 1970 +0000"},
+		{hashC, "", "devel +ccccccc"},
+		// A release branch with no tag at HEAD is a development version.
+		{"ref: refs/heads/release-branch.go1.11", "", "devel +ccccccc"},
+	}
+	for _, tt := range tests {
+		os.Setenv("SOURCE_DATE_EPOCH", tt.epoch)
+		goroot = gitFixture(t, map[string]string{
+			".git/HEAD": tt.head + "\n",
+			".git/packed-refs": hashT + " refs/tags/go1.12rc1\n" +
+				"^" + hashB + "\n" +
+				hashB + " refs/heads/master\n" +
+				hashB + " refs/heads/release-branch.go1.12\n" +
+				hashC + " refs/heads/release-branch.go1.11\n",
+		})
+		if got := gitversion(); got != tt.want {
+			t.Errorf("HEAD %s, SOURCE_DATE_EPOCH=%q: gitversion() = %q, want %q", tt.head, tt.epoch, got, tt.want)
+		}
+		os.RemoveAll(goroot)
+	}
+}
+
+func TestVersionFormat(t *testing.T) {
+	good := []string{
+		"go1",
+		"go1.12",
+		"go1.12.1",
+		"go1.0",
+		"go1.12beta1",
+		"go1.12rc2",
+		"go1.12.1rc1",
+		"go1.10beta12",
+		"devel",
+		"devel +abcdef0 Mon Dec 31 16:00:00 2018 -0800",
+		"devel my build",
+	}
+	bad := []string{
+		"",
+		"go",
+		"go2",
+		"go1.",
+		"go1.012",
+		"go1.12.",
+		"go1.12.1.1",
+		"go1.12beta",
+		"go1.12beta0",
+		"go1.12alpha1",
+		"go1.12 rc1",
+		"1.12",
+		" go1.12",
+		"go1.12\n",
+		"develop",
+		"devel ",
+		"devel \x00",
+		"devel a\nb",
+	}
+	for _, v := range good {
+		if !versionFormatRE.MatchString(v) {
+			t.Errorf("version -set rejects %q", v)
+		}
+	}
+	for _, v := range bad {
+		if versionFormatRE.MatchString(v) {
+			t.Errorf("version -set accepts %q", v)
+		}
+	}
+}
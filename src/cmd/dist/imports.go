@@ -214,32 +214,65 @@ func readComments(f io.Reader) ([]byte, error) {
 	return r.buf, r.err
 }
 
-// readimports returns the imports found in the named file.
-func readimports(file string) []string {
-	var imports []string
+// A fileHeader is what dist reads from the start of a source file.
+type fileHeader struct {
+	comments string   // the leading comments, which hold any build constraints
+	pkg      string   // the package name; "" if not a Go file
+	imports  []string // the imported paths
+}
+
+// readHeader reads the header of the named file: its leading comments
+// and, for a Go file, the package clause and imports after them.
+// It is the one parser behind both readimports and shouldbuild.
+func readHeader(file string) *fileHeader {
+	h := new(fileHeader)
 	r := &importReader{b: bufio.NewReader(strings.NewReader(readfile(file)))}
+	r.peekByte(true)
+	h.comments = string(r.buf)
+	if r.err == nil && !r.eof {
+		// Didn't reach EOF, so must have found a non-space byte. Remove it.
+		h.comments = h.comments[:len(h.comments)-1]
+	}
+	if !strings.HasSuffix(file, ".go") {
+		return h
+	}
+
 	r.readKeyword("package")
+	r.peekByte(true)
+	start := len(r.buf) - 1
 	r.readIdent()
+	if r.err == nil && start >= 0 {
+		end := len(r.buf)
+		if r.peek != 0 {
+			end-- // the byte after the name
+		}
+		h.pkg = string(r.buf[start:end])
+	}
+
 	for r.peekByte(true) == 'i' {
 		r.readKeyword("import")
 		if r.peekByte(true) == '(' {
 			r.nextByte(false)
 			for r.peekByte(true) != ')' && r.err == nil {
-				r.readImport(&imports)
+				r.readImport(&h.imports)
 			}
 			r.nextByte(false)
 		} else {
-			r.readImport(&imports)
+			r.readImport(&h.imports)
 		}
 	}
 
-	for i := range imports {
-		unquoted, err := strconv.Unquote(imports[i])
+	for i := range h.imports {
+		unquoted, err := strconv.Unquote(h.imports[i])
 		if err != nil {
 			fatalf("reading imports from %s: %v", file, err)
 		}
-		imports[i] = unquoted
+		h.imports[i] = unquoted
 	}
+	return h
+}
 
-	return imports
+// readimports returns the imports found in the named file.
+func readimports(file string) []string {
+	return readHeader(file).imports
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+)
+
+/*
+ * Background jobs.
+ *
+ * install runs the compiler, assembler and linker commands for
+ * each package as background jobs, so that up to maxbg of them
+ * run at once across all the packages being installed. A job's
+ * output is buffered and printed in one piece once it finishes,
+ * under the command line that produced it.
+ *
+ * By default the first failing job stops the build: jobs not yet
+ * started are dropped, running commands are killed, and dist exits.
+ * With -k, a failure only stops the package it belongs to and the
+ * packages that import it, and everything else is still built.
+ * Either way dist ends with a summary of all the failures.
+ */
+
+var maxbg = 4 // maximum number of jobs to run at once; see -p
+
+// keepGoing reports whether -k was given.
+var keepGoing bool
+
+// addJobFlags adds the -k and -p flags to the commands
+// that run background jobs.
+func addJobFlags() {
+	flag.BoolVar(&keepGoing, "k", keepGoing, "keep going after a command fails, building what does not depend on it")
+	flag.IntVar(&maxbg, "p", maxbg, "run at most this many commands at once")
+}
+
+// A canceler is closed to cancel the work that watches it.
+// It is the part of context.Context that the job pool needs;
+// cmd/dist cannot use package context, as it builds with Go 1.4.
+type canceler struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func newCanceler() *canceler {
+	return &canceler{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once c is canceled.
+func (c *canceler) Done() <-chan struct{} {
+	return c.done
+}
+
+// canceled reports whether c has been canceled.
+func (c *canceler) canceled() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancel cancels c. It reports whether this call did so,
+// as opposed to an earlier one.
+func (c *canceler) cancel() bool {
+	first := false
+	c.once.Do(func() {
+		close(c.done)
+		first = true
+	})
+	return first
+}
+
+// bgcancel is canceled when dist is about to exit,
+// to stop all the background jobs.
+var bgcancel = newCanceler()
+
+var (
+	jobSlotsOnce sync.Once
+	jobSlots     chan struct{} // holds a token for each running job
+
+	jobsMu     sync.Mutex
+	jobsActive int // number of jobs running a command
+	jobsIdle   = sync.NewCond(&jobsMu)
+)
+
+// A jobGroup is a set of background jobs started by bgrun,
+// which a caller can wait for with bgwait.
+type jobGroup struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	failed bool // a job in the group failed or was canceled
+}
+
+func (g *jobGroup) fail() {
+	g.mu.Lock()
+	g.failed = true
+	g.mu.Unlock()
+}
+
+// A jobFailure records one failure for the summary.
+type jobFailure struct {
+	what string // a command line, or a package
+	why  string
+}
+
+var (
+	failuresMu      sync.Mutex
+	failures        []jobFailure
+	failuresPrinted bool
+)
+
+// addFailure records that what failed, because of why.
+func addFailure(what, why string) {
+	failuresMu.Lock()
+	failures = append(failures, jobFailure{what, why})
+	failuresMu.Unlock()
+}
+
+// bgrun is like run but runs the command in the background,
+// as part of the group g.
+// CheckExit|ShowOutput mode is implied (since output cannot be returned).
+func bgrun(g *jobGroup, dir string, cmd ...string) {
+	jobSlotsOnce.Do(func() {
+		if maxbg < 1 {
+			maxbg = 1
+		}
+		jobSlots = make(chan struct{}, maxbg)
+	})
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		select {
+		case jobSlots <- struct{}{}:
+		case <-bgcancel.Done():
+			g.fail()
+			return
+		}
+		defer func() { <-jobSlots }()
+
+		// Once dist has started to exit, it waits for the active
+		// jobs to finish; do not start any more.
+		jobsMu.Lock()
+		if bgcancel.canceled() {
+			jobsMu.Unlock()
+			g.fail()
+			return
+		}
+		jobsActive++
+		jobsMu.Unlock()
+		defer func() {
+			jobsMu.Lock()
+			jobsActive--
+			jobsIdle.Broadcast()
+			jobsMu.Unlock()
+		}()
+
+		out, err := runCmd(dir, ShowOutput|Background, bgcancel.Done(), cmd...)
+		if err != nil && bgcancel.canceled() {
+			// Killed because dist is exiting; not a failure of its own.
+			g.fail()
+			return
+		}
+		line := strings.Join(cmd, " ")
+		if dir != "" {
+			line = "cd " + dir + "; " + line
+		}
+		outputLock.Lock()
+		if out != "" || err != nil {
+			xprintf("# %s\n%s", line, out)
+			if out != "" && !strings.HasSuffix(out, "\n") {
+				xprintf("\n")
+			}
+		}
+		if err != nil {
+			xprintf("FAILED: %v\n", err)
+		}
+		outputLock.Unlock()
+		if err == nil {
+			return
+		}
+
+		g.fail()
+		addFailure(strings.Join(cmd, " "), err.Error())
+		if !keepGoing && bgcancel.cancel() {
+			printFailures()
+			die(1)
+		}
+	}()
+}
+
+// bgwait waits for the jobs in g to finish, or for dist to start
+// exiting. It reports whether all of them succeeded.
+func bgwait(g *jobGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-bgcancel.Done():
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.failed
+}
+
+// waitJobs waits until at most n jobs are running commands.
+// Since bgcancel has been canceled, no new ones will start.
+func waitJobs(n int) {
+	jobsMu.Lock()
+	for jobsActive > n {
+		jobsIdle.Wait()
+	}
+	jobsMu.Unlock()
+}
+
+// printFailures prints a summary of the recorded failures,
+// if there are any and it has not already done so,
+// and returns their number.
+func printFailures() int {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	if len(failures) == 0 || failuresPrinted {
+		return len(failures)
+	}
+	failuresPrinted = true
+	if len(failures) == 1 {
+		errprintf("go tool dist: FAILED: %s: %s\n", failures[0].what, failures[0].why)
+		return 1
+	}
+	errprintf("go tool dist: %d failures:\n", len(failures))
+	for _, f := range failures {
+		errprintf("\t%s: %s\n", f.what, f.why)
+	}
+	return len(failures)
+}
+
+// checkFailures exits, after printing a summary, if any
+// background jobs failed. Commands running with -k call it
+// at the points where they can go no further after a failure.
+func checkFailures() {
+	if printFailures() > 0 {
+		die(0)
+	}
+}
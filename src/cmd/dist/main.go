@@ -7,11 +7,9 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime"
 	"strings"
-	"time"
 )
 
 func usage() {
@@ -20,14 +18,28 @@ Commands are:
 
 banner         print installation banner
 bootstrap      rebuild everything
-clean          deletes all built files
-env [-p]       print environment (-p: include $PATH)
+clean [-n]     deletes generated files (-n: list them; see -h for selectors)
+distpack [-target=os/arch]
+               build a distribution archive for os/arch
+doctor [-json] check the host environment before building
+env [-p] [-json] [-fish] [-powershell] [-why]
+               print environment (-p: include $PATH;
+               -why: say where values came from)
+generate [-check]
+               regenerate the z files (-check: report stale ones)
+graph [dirs]   print the package dependency graph used by install
 install [dir]  install individual directory
 list [-json]   list all supported platforms
+pack list|extract file.a
+               list or extract the members of a Go archive
+report [file]  summarize a build event log
 test [-h]      run Go test(s)
-version        print Go version
+version [-set] print Go version (-set: record it in VERSION)
 
-All commands take -v flags to emit extra information.
+All commands take -v flags to emit extra information,
+and -eventlog=file to append a JSON event for every command run.
+bootstrap and install also take -p=n to run at most n commands at once,
+and -k to keep building what does not depend on a failed command.
 `)
 	xexit(2)
 }
@@ -37,23 +49,21 @@ var commands = map[string]func(){
 	"banner":    cmdbanner,
 	"bootstrap": cmdbootstrap,
 	"clean":     cmdclean,
+	"distpack":  cmddistpack,
+	"doctor":    cmddoctor,
 	"env":       cmdenv,
+	"generate":  cmdgenerate,
+	"graph":     cmdgraph,
 	"install":   cmdinstall,
 	"list":      cmdlist,
+	"pack":      cmdpack,
+	"report":    cmdreport,
 	"test":      cmdtest,
 	"version":   cmdversion,
 }
 
-var _log_print_level int = 1
-
-func main() {
-	log.SetPrefix("TRACE: ")
-	log.SetFlags(log.Ltime)
-	main1()
-}
-
 // main takes care of OS-specific startup and dispatches to xmain.
-func main1() {
+func main() {
 	os.Setenv("TERM", "dumb") // disable escape codes in clang errors
 
 	// provide -check-armv6k first, before checking for $GOROOT so that
@@ -65,11 +75,13 @@ func main1() {
 	}
 
 	gohostos = runtime.GOOS
+	settingOrigin["GOHOSTOS"] = "host (runtime.GOOS)"
 	switch gohostos {
 	case "darwin":
 		// Even on 64-bit platform, darwin uname -m prints i386.
 		// We don't support any of the OS X versions that run on 32-bit-only hardware anymore.
 		gohostarch = "amd64"
+		settingOrigin["GOHOSTARCH"] = "default for darwin"
 		// macOS 10.9 and later require clang
 		defaultclang = true
 	case "freebsd":
@@ -85,6 +97,7 @@ func main1() {
 	case "solaris":
 		// Even on 64-bit platform, solaris uname -m prints i86pc.
 		out := run("", CheckExit, "isainfo", "-n")
+		settingOrigin["GOHOSTARCH"] = "host probe (isainfo -n)"
 		if strings.Contains(out, "amd64") {
 			gohostarch = "amd64"
 		}
@@ -93,6 +106,7 @@ func main1() {
 		}
 	case "plan9":
 		gohostarch = os.Getenv("objtype")
+		settingOrigin["GOHOSTARCH"] = "environment ($objtype)"
 		if gohostarch == "" {
 			fatalf("$objtype is unset")
 		}
@@ -101,6 +115,7 @@ func main1() {
 	case "aix":
 		// uname -m doesn't work under AIX
 		gohostarch = "ppc64"
+		settingOrigin["GOHOSTARCH"] = "default for aix"
 	}
 
 	sysinit()
@@ -108,6 +123,7 @@ func main1() {
 	if gohostarch == "" {
 		// Default Unix system.
 		out := run("", CheckExit, "uname", "-m")
+		settingOrigin["GOHOSTARCH"] = "host probe (uname -m)"
 		switch {
 		case strings.Contains(out, "x86_64"), strings.Contains(out, "amd64"):
 			gohostarch = "amd64"
@@ -145,7 +161,6 @@ func main1() {
 	if gohostarch == "arm" || gohostarch == "mips64" || gohostarch == "mips64le" {
 		maxbg = min(maxbg, runtime.NumCPU())
 	}
-	bginit()
 
 	if len(os.Args) > 1 && os.Args[1] == "-check-goarm" {
 		useVFPv1() // might fail with SIGILL
@@ -155,8 +170,10 @@ func main1() {
 		os.Exit(0)
 	}
 
+	initKeepGoing = len(os.Args) > 1 && os.Args[1] == "doctor"
 	xinit()
 	xmain()
+	checkFailures()
 	xexit(0)
 }
 
@@ -179,51 +196,3 @@ func xmain() {
 		usage()
 	}
 }
-
-// function list for zxy
-func _p(lev int, args ...interface{}) {
-	if lev < _log_print_level {
-		return
-	}
-
-	log.Println(args...)
-}
-
-func _pf(lev int, format string, args ...interface{}) {
-	if lev < _log_print_level {
-		return
-	}
-	t := _nowTime()
-	fmt.Print(t, " ")
-	fmt.Printf(format, args...)
-}
-
-func _pfs(lev int, format string, args ...string) {
-	if lev < _log_print_level {
-		return
-	}
-	s := []interface{}{}
-	for _, v := range args {
-		s = append(s, v)
-	}
-	t := _nowTime()
-	fmt.Print(t, " ")
-	fmt.Printf(format, s...)
-}
-
-func _logret() {
-	_, file1, line1, _ := runtime.Caller(1)
-	_pf(100, "RETURN AT:%s:%d\n", file1, line1)
-}
-
-func _nowTime() string {
-	x := time.Now().Format("[15:04:05]")
-	return x
-}
-
-func _sleep(sec uint) {
-	if sec <= 0 {
-		return
-	}
-	time.Sleep(time.Duration(sec) * time.Second)
-}
@@ -0,0 +1,141 @@
+package main
+
+import "strings"
+
+// A platform describes one supported GOOS/GOARCH pair.
+type platform struct {
+	name       string // "goos/goarch"
+	flags      platformFlags
+	buildmodes string // space-separated -buildmode values beyond archive and exe
+}
+
+type platformFlags int
+
+const (
+	platCgo        platformFlags = 1 << iota // cgo is supported
+	platFirstClass                           // a first class port; see golang.org/wiki/PortingPolicy
+	platIncomplete                           // supported but not complete yet; see golang.org/issue/28944
+	platRace                                 // the race detector is supported
+	platMSan                                 // the memory sanitizer is supported
+)
+
+// platforms lists all supported platforms. It is the single point
+// of truth for them: cgoEnabled and incomplete are derived from it,
+// as are the answers of raceDetectorSupported, mSanSupported and
+// tester.supportedBuildmode, and through cgoEnabled the lists that
+// mkzosarch and mkzcgo generate for go/build and cmd/go.
+// It is also what 'go tool dist list' prints.
+//
+// Cannot use go/build or cmd/internal/sys directly because cmd/dist
+// for a new release builds against an old release's packages,
+// which may be out of sync.
+var platforms = []platform{
+	{"aix/ppc64", 0, ""},
+	{"android/386", platCgo, "c-shared pie"},
+	{"android/amd64", platCgo, "pie"},
+	{"android/arm", platCgo, "c-shared pie"},
+	{"android/arm64", platCgo, "c-shared pie"},
+	{"darwin/386", platCgo, "c-archive c-shared"},
+	{"darwin/amd64", platCgo | platFirstClass | platRace, "c-archive c-shared plugin pie"},
+	{"darwin/arm", platCgo, "c-archive"},
+	{"darwin/arm64", platCgo, "c-archive"},
+	{"dragonfly/amd64", platCgo, ""},
+	{"freebsd/386", platCgo, ""},
+	{"freebsd/amd64", platCgo | platRace, "c-archive c-shared"},
+	{"freebsd/arm", 0, ""},
+	{"js/wasm", 0, ""},
+	{"linux/386", platCgo | platFirstClass, "c-archive c-shared shared plugin pie"},
+	{"linux/amd64", platCgo | platFirstClass | platRace | platMSan, "c-archive c-shared shared plugin pie"},
+	{"linux/arm", platCgo | platFirstClass, "c-shared shared plugin pie"},
+	// linux/arm64 lacks plugin because it causes the external linker
+	// to crash, see https://golang.org/issue/17138
+	{"linux/arm64", platCgo | platFirstClass | platRace | platMSan, "c-shared shared pie"},
+	{"linux/mips", platCgo, ""},
+	{"linux/mips64", platCgo, ""},
+	{"linux/mips64le", platCgo, ""},
+	{"linux/mipsle", platCgo, ""},
+	{"linux/ppc64", 0, ""},
+	{"linux/ppc64le", platCgo | platRace, "c-archive c-shared shared plugin pie"},
+	{"linux/riscv64", platCgo | platIncomplete, ""},
+	{"linux/s390x", platCgo, "c-archive c-shared shared plugin pie"},
+	{"linux/sparc64", platCgo | platIncomplete, ""},
+	{"nacl/386", 0, ""},
+	{"nacl/amd64p32", 0, ""},
+	{"nacl/arm", 0, ""},
+	{"netbsd/386", platCgo, ""},
+	{"netbsd/amd64", platCgo | platRace, ""},
+	{"netbsd/arm", platCgo, ""},
+	{"openbsd/386", platCgo, ""},
+	{"openbsd/amd64", platCgo, ""},
+	{"openbsd/arm", platCgo, ""},
+	{"plan9/386", 0, ""},
+	{"plan9/amd64", 0, ""},
+	{"plan9/arm", 0, ""},
+	{"solaris/amd64", platCgo, ""},
+	{"windows/386", platCgo | platFirstClass, "c-archive c-shared"},
+	{"windows/amd64", platCgo | platFirstClass | platRace, "c-archive c-shared"},
+	{"windows/arm", 0, ""},
+}
+
+// An archInfo records the facts about a GOARCH that
+// cmd/internal/sys.Arch records for the compiler.
+type archInfo struct {
+	ptrSize   int
+	bigEndian bool
+}
+
+var archs = map[string]archInfo{
+	"386":      {4, false},
+	"amd64":    {8, false},
+	"amd64p32": {4, false},
+	"arm":      {4, false},
+	"arm64":    {8, false},
+	"mips":     {4, true},
+	"mipsle":   {4, false},
+	"mips64":   {8, true},
+	"mips64le": {8, false},
+	"ppc64":    {8, true},
+	"ppc64le":  {8, false},
+	"riscv64":  {8, false},
+	"s390x":    {8, true},
+	"sparc64":  {8, true},
+	"wasm":     {8, false},
+}
+
+// findPlatform returns the entry for goos/goarch in platforms,
+// or nil if that is not a supported platform.
+func findPlatform(goos, goarch string) *platform {
+	name := goos + "/" + goarch
+	for i := range platforms {
+		if platforms[i].name == name {
+			return &platforms[i]
+		}
+	}
+	return nil
+}
+
+// platformHas reports whether goos/goarch is supported and has all of flags.
+func platformHas(goos, goarch string, flags platformFlags) bool {
+	p := findPlatform(goos, goarch)
+	return p != nil && p.flags&flags == flags
+}
+
+// platformBuildmode reports whether goos/goarch supports the
+// given -buildmode, other than archive and exe.
+func platformBuildmode(goos, goarch, mode string) bool {
+	p := findPlatform(goos, goarch)
+	return p != nil && find(mode, strings.Fields(p.buildmodes)) >= 0
+}
+
+// platformMap returns a map from the name of each platform
+// to whether it has flag. If all is false, the map includes
+// only the platforms that have flag.
+func platformMap(flag platformFlags, all bool) map[string]bool {
+	m := make(map[string]bool)
+	for _, p := range platforms {
+		if has := p.flags&flag != 0; has || all {
+			m[p.name] = has
+		}
+	}
+	return m
+}
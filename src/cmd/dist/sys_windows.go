@@ -39,6 +39,7 @@ var sysinfo systeminfo
 
 func sysinit() {
 	syscall.Syscall(procGetSystemInfo.Addr(), 1, uintptr(unsafe.Pointer(&sysinfo)), 0, 0)
+	settingOrigin["GOHOSTARCH"] = "host probe (GetSystemInfo)"
 	switch sysinfo.wProcessorArchitecture {
 	case PROCESSOR_ARCHITECTURE_AMD64:
 		gohostarch = "amd64"
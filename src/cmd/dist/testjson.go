@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// jsonPackage is the Package reported in the events of dist test -json.
+const jsonPackage = "cmd/dist"
+
+// A testEvent is one event printed by dist test -json.
+// It has the same shape as the events printed by go test -json
+// (see cmd/internal/test2json), with each distTest reported as a test.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string   `json:",omitempty"`
+	Test    string   `json:",omitempty"`
+	Elapsed *float64 `json:",omitempty"`
+	Output  *string  `json:",omitempty"`
+}
+
+// emit prints a JSON event for dt, or for the whole run if dt is nil.
+func (t *tester) emit(dt *distTest, action string, elapsed *float64, output *string) {
+	ev := testEvent{
+		Time:    time.Now(),
+		Action:  action,
+		Package: jsonPackage,
+		Elapsed: elapsed,
+		Output:  output,
+	}
+	if dt != nil {
+		ev.Test = dt.name
+	}
+	js, err := json.Marshal(&ev)
+	if err != nil {
+		fatalf("json marshal error: %v", err)
+	}
+	js = append(js, '\n')
+	t.jsonOut.Write(js)
+}
+
+// stdout returns the writer for output produced by dt.
+// With -json it turns that output into output events for dt.
+func (t *tester) stdout(dt *distTest) io.Writer {
+	if !t.json {
+		return os.Stdout
+	}
+	if dt.out == nil {
+		dt.out = &testOutput{t: t, dt: dt}
+	}
+	return dt.out
+}
+
+// stderr is like stdout but for error output, which -json
+// reports in the same output events as ordinary output.
+func (t *tester) stderr(dt *distTest) io.Writer {
+	if !t.json {
+		return os.Stderr
+	}
+	return t.stdout(dt)
+}
+
+// testStarted records that dt is about to run.
+func (t *tester) testStarted(dt *distTest) {
+	dt.start = time.Now()
+	t.started = append(t.started, dt)
+	if t.json {
+		t.emit(dt, "run", nil, nil)
+	}
+}
+
+// testMaybeDone records the run time of dt and reports its result
+// once its function has returned and all the commands it queued
+// with addCmd have finished.
+func (t *tester) testMaybeDone(dt *distTest) {
+	if !dt.fnDone || dt.pending > 0 || dt.reported {
+		return
+	}
+	dt.reported = true
+	t.recordTime(dt, dt.elapsed)
+	if !t.json {
+		return
+	}
+	if dt.out != nil {
+		dt.out.flush()
+	}
+	action := "pass"
+	switch {
+	case dt.failed:
+		action = "fail"
+	case dt.skipped:
+		action = "skip"
+	}
+	elapsed := dt.elapsed.Seconds()
+	t.emit(dt, action, &elapsed, nil)
+}
+
+// testsAborted reports the result of the tests that have started but
+// not finished, as failures, and of the whole run, before dist test
+// exits on a failure without -k. That way every test started has a
+// final event. Their elapsed time is the wall time since they started.
+func (t *tester) testsAborted() {
+	if !t.json {
+		return
+	}
+	for _, dt := range t.started {
+		if dt.reported {
+			continue
+		}
+		dt.reported = true
+		if dt.out != nil {
+			dt.out.flush()
+		}
+		elapsed := time.Since(dt.start).Seconds()
+		t.emit(dt, "fail", &elapsed, nil)
+	}
+	t.runDone("fail")
+}
+
+// runDone reports the result of the whole run, with its elapsed time.
+func (t *tester) runDone(action string) {
+	elapsed := time.Since(t.start).Seconds()
+	t.emit(nil, action, &elapsed, nil)
+}
+
+// A testOutput is an io.Writer that turns each line written to it
+// into an output event for its test.
+type testOutput struct {
+	t   *tester
+	dt  *distTest
+	buf []byte // incomplete last line
+}
+
+func (o *testOutput) Write(b []byte) (int, error) {
+	o.buf = append(o.buf, b...)
+	for {
+		i := bytes.IndexByte(o.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(o.buf[:i+1])
+		o.t.emit(o.dt, "output", nil, &line)
+		o.buf = o.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush emits any incomplete last line.
+func (o *testOutput) flush() {
+	if len(o.buf) > 0 {
+		line := string(o.buf)
+		o.t.emit(o.dt, "output", nil, &line)
+		o.buf = nil
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestJSONAborted checks that, when dist test gives up after a failure,
+// every test it started gets a final event with an elapsed time.
+func TestJSONAborted(t *testing.T) {
+	var buf bytes.Buffer
+	tt := &tester{json: true, jsonOut: &buf, start: time.Now()}
+	tt.loadHistory()
+
+	done := &distTest{name: "done"}
+	running := &distTest{name: "running"}
+	tt.testStarted(done)
+	tt.testStarted(running)
+	done.fnDone = true
+	done.failed = true
+	done.elapsed = 2 * time.Second
+	tt.testMaybeDone(done)
+	tt.testsAborted()
+
+	var got []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev testEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		s := ev.Action + " " + ev.Test
+		if ev.Action != "run" {
+			if ev.Elapsed == nil {
+				t.Errorf("%s event has no Elapsed", s)
+			} else if ev.Test == "done" && *ev.Elapsed != 2 {
+				t.Errorf("%s event has Elapsed %v, want 2", s, *ev.Elapsed)
+			}
+		}
+		got = append(got, s)
+	}
+	want := []string{"run done", "run running", "fail done", "fail running", "fail "}
+	if len(got) != len(want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("events = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseShard parses the value of dist test's -shard flag,
+// "i/n" with 0 <= i < n, into the shard index and count.
+func parseShard(s string) (shard, shards int, err error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid -shard=%s: want i/n", s)
+	}
+	shard, err1 := strconv.Atoi(s[:i])
+	shards, err2 := strconv.Atoi(s[i+1:])
+	if err1 != nil || err2 != nil || shards < 1 || shard < 0 || shard >= shards {
+		return 0, 0, fmt.Errorf("invalid -shard=%s: want i/n with 0 <= i < n", s)
+	}
+	return shard, shards, nil
+}
+
+// readTestTimes reads a file of recorded test durations,
+// one "name seconds" pair per line.
+func readTestTimes(file string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	times := map[string]float64{}
+	for i, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed line", file, i+1)
+		}
+		sec, err := strconv.ParseFloat(f[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		times[f[0]] = sec
+	}
+	return times, nil
+}
+
+// assignShards assigns each of t.tests to one of t.shards shards,
+// recording the result in t.shardOf. The assignment depends only
+// on the test names and times, so every worker computes the same one.
+//
+// Without recorded times a test's shard is a hash of its name.
+// Otherwise the tests are placed longest first, each on the shard
+// with the least total time so far; tests with no recorded time
+// count as the average of those that have one.
+func (t *tester) assignShards(times map[string]float64) {
+	t.shardOf = map[string]int{}
+	if len(times) == 0 {
+		for _, dt := range t.tests {
+			h := fnv.New32a()
+			h.Write([]byte(dt.name))
+			t.shardOf[dt.name] = int(h.Sum32() % uint32(t.shards))
+		}
+		return
+	}
+
+	var avg float64
+	for _, sec := range times {
+		avg += sec
+	}
+	avg /= float64(len(times))
+
+	list := make([]shardTest, len(t.tests))
+	for i, dt := range t.tests {
+		sec, ok := times[dt.name]
+		if !ok {
+			sec = avg
+		}
+		list[i] = shardTest{dt.name, sec}
+	}
+	sort.Sort(byShardTime(list))
+
+	load := make([]float64, t.shards)
+	for _, st := range list {
+		min := 0
+		for i := range load {
+			if load[i] < load[min] {
+				min = i
+			}
+		}
+		t.shardOf[st.name] = min
+		load[min] += st.sec
+	}
+}
+
+type shardTest struct {
+	name string
+	sec  float64
+}
+
+// byShardTime sorts tests by decreasing time, then by name.
+type byShardTime []shardTest
+
+func (x byShardTime) Len() int      { return len(x) }
+func (x byShardTime) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x byShardTime) Less(i, j int) bool {
+	if x[i].sec != x[j].sec {
+		return x[i].sec > x[j].sec
+	}
+	return x[i].name < x[j].name
+}
+
+// inShard reports whether the test name belongs to this worker's shard.
+func (t *tester) inShard(name string) bool {
+	return t.shards <= 1 || t.shardOf[name] == t.shard
+}
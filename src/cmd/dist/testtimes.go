@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+)
+
+/*
+ * Test timing history.
+ *
+ * dist test records how long each distTest took in a history
+ * file, in the same "name seconds" format read by -shard-times.
+ * The history is used to warn about tests that got much slower
+ * and to start the longest pending tests first.
+ */
+
+// minSlowTest is the shortest duration for which dist test warns
+// about a slowdown; shorter tests are too noisy to compare.
+const minSlowTest = 5 * time.Second
+
+// loadHistory reads the timing history, if there is one.
+func (t *tester) loadHistory() {
+	t.history = map[string]float64{}
+	t.newTimes = map[string]float64{}
+	if t.historyFile == "" || !isfile(t.historyFile) {
+		return
+	}
+	times, err := readTestTimes(t.historyFile)
+	if err != nil {
+		log.Printf("warning: ignoring test history: %v", err)
+		return
+	}
+	t.history = times
+}
+
+// recordTime records that dt took elapsed to run, and warns
+// if that is more than t.slowFactor times its recorded duration.
+func (t *tester) recordTime(dt *distTest, elapsed time.Duration) {
+	if t.compileOnly || dt.failed || dt.skipped {
+		return
+	}
+	if old, ok := t.history[dt.name]; ok && t.slowFactor > 0 && elapsed >= minSlowTest {
+		if sec := elapsed.Seconds(); sec > t.slowFactor*old {
+			log.Printf("warning: %s took %.1fs, %.1fx its previous %.1fs", dt.name, sec, sec/old, old)
+		}
+	}
+	t.newTimes[dt.name] = elapsed.Seconds()
+}
+
+// saveHistory writes the timing history back, updated with the
+// durations recorded by this run. Failing to write it, as in a
+// read-only GOROOT, only draws a warning: the tests still passed.
+func (t *tester) saveHistory() {
+	if t.historyFile == "" || len(t.newTimes) == 0 {
+		return
+	}
+	for name, sec := range t.newTimes {
+		t.history[name] = sec
+	}
+	var names []string
+	for name := range t.history {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %.3f\n", name, t.history[name])
+	}
+	if err := ioutil.WriteFile(t.historyFile, buf.Bytes(), 0666); err != nil {
+		log.Printf("warning: not saving test history: %v", err)
+	}
+}
+
+// sortWorklist orders list so that the commands of the tests with
+// the longest recorded durations start first, which shortens the
+// wall-clock time of runPending. The commands of a single test
+// stay together and in order. Tests without a recorded duration
+// are treated as the longest, since nothing is known about them.
+func (t *tester) sortWorklist(list []*work) {
+	sort.Stable(byRecordedTime{list, t.history})
+}
+
+type byRecordedTime struct {
+	list    []*work
+	history map[string]float64
+}
+
+func (x byRecordedTime) Len() int      { return len(x.list) }
+func (x byRecordedTime) Swap(i, j int) { x.list[i], x.list[j] = x.list[j], x.list[i] }
+func (x byRecordedTime) Less(i, j int) bool {
+	ti, oki := x.history[x.list[i].dt.name]
+	tj, okj := x.history[x.list[j].dt.name]
+	if !oki || !okj {
+		return !oki && okj
+	}
+	return ti > tj
+}
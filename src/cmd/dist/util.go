@@ -63,19 +63,38 @@ var outputLock sync.Mutex
 // If mode has ShowOutput set and Background unset, run passes cmd's output to
 // stdout/stderr directly. Otherwise, run returns cmd's output as a string.
 // If mode has CheckExit set and the command fails, run calls fatalf.
-// If mode has Background set, this command is being run as a
-// Background job. Only bgrun should use the Background mode,
-// not other callers.
+// Background jobs are run by bgrun, using runCmd.
 func run(dir string, mode int, cmd ...string) string {
+	out, err := runCmd(dir, mode, nil, cmd...)
+	if err != nil && mode&CheckExit != 0 {
+		outputLock.Lock()
+		if len(out) > 0 {
+			xprintf("%s\n", out)
+		}
+		outputLock.Unlock()
+		fatalf("FAILED: %v: %v", strings.Join(cmd, " "), err)
+	}
+	if mode&ShowOutput != 0 {
+		outputLock.Lock()
+		os.Stdout.WriteString(out)
+		outputLock.Unlock()
+	}
+	return out
+}
+
+// runCmd runs the command line cmd in dir, returning its output
+// and the error, if any, from running it. If cancel is closed
+// before the command finishes, runCmd kills it.
+// Of the mode bits, runCmd only uses ShowOutput and Background,
+// in the same way as run.
+func runCmd(dir string, mode int, cancel <-chan struct{}, cmd ...string) (string, error) {
 	if vflag > 1 {
 		errprintf("run: %s\n", strings.Join(cmd, " "))
 	}
 
-	_pf(1, "exec: %s/ %s\n", dir, strings.Join(cmd, " "))
 	xcmd := exec.Command(cmd[0], cmd[1:]...)
 	xcmd.Dir = dir
-	var data []byte
-	var err error
+	var data bytes.Buffer
 
 	// If we want to show command output and this is not
 	// a background command, assume it's the only thing
@@ -84,96 +103,46 @@ func run(dir string, mode int, cmd ...string) string {
 	// other command's output. Not buffering lets the output
 	// appear as it is printed instead of once the command exits.
 	// This is most important for the invocation of 'go1.4 build -v bootstrap/...'.
+	var mu sync.Mutex
+	stdout := &countWriter{mu: &mu, w: &data}
+	stderr := &countWriter{mu: &mu, w: &data}
 	if mode&(Background|ShowOutput) == ShowOutput {
-		xcmd.Stdout = os.Stdout
-		xcmd.Stderr = os.Stderr
-		err = xcmd.Run()
-	} else {
-		data, err = xcmd.CombinedOutput()
-	}
-	if err != nil && mode&CheckExit != 0 {
-		outputLock.Lock()
-		if len(data) > 0 {
-			xprintf("%s\n", data)
-		}
-		outputLock.Unlock()
-		if mode&Background != 0 {
-			// Prevent fatalf from waiting on our own goroutine's
-			// bghelper to exit:
-			bghelpers.Done()
+		stdout.w = os.Stdout
+		stderr.w = os.Stderr
+	}
+	xcmd.Stdout = stdout
+	xcmd.Stderr = stderr
+	start := time.Now()
+	err := xcmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() {
+			done <- xcmd.Wait()
+		}()
+		select {
+		case err = <-done:
+		case <-cancel:
+			xcmd.Process.Kill()
+			err = <-done
 		}
-		fatalf("FAILED: %v: %v", strings.Join(cmd, " "), err)
-	}
-	if mode&ShowOutput != 0 {
-		outputLock.Lock()
-		os.Stdout.Write(data)
-		outputLock.Unlock()
 	}
+	logEvent(&buildEvent{
+		Op:         "run",
+		Start:      start,
+		End:        time.Now(),
+		Cmd:        cmd,
+		Dir:        dir,
+		Exit:       exitStatus(err),
+		Stdout:     stdout.n,
+		Stderr:     stderr.n,
+		Background: mode&Background != 0,
+	})
 	if vflag > 2 {
 		errprintf("run: %s DONE\n", strings.Join(cmd, " "))
 	}
-	return string(data)
-}
-
-var maxbg = 4 /* maximum number of jobs to run at once */
-
-var (
-	bgwork = make(chan func(), 1e5)
-
-	bghelpers sync.WaitGroup
-
-	dieOnce sync.Once // guards close of dying
-	dying   = make(chan struct{})
-)
-
-func bginit() {
-	bghelpers.Add(maxbg)
-	for i := 0; i < maxbg; i++ {
-		go bghelper()
-	}
-}
-
-func bghelper() {
-	defer bghelpers.Done()
-	for {
-		select {
-		case <-dying:
-			return
-		case w := <-bgwork:
-			// Dying takes precedence over doing more work.
-			select {
-			case <-dying:
-				return
-			default:
-				w()
-			}
-		}
-	}
-}
-
-// bgrun is like run but runs the command in the background.
-// CheckExit|ShowOutput mode is implied (since output cannot be returned).
-// bgrun adds 1 to wg immediately, and calls Done when the work completes.
-func bgrun(wg *sync.WaitGroup, dir string, cmd ...string) {
-	wg.Add(1)
-	bgwork <- func() {
-		defer wg.Done()
-		run(dir, CheckExit|ShowOutput|Background, cmd...)
-	}
-}
-
-// bgwait waits for pending bgruns to finish.
-// bgwait must be called from only a single goroutine at a time.
-func bgwait(wg *sync.WaitGroup) {
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
-	select {
-	case <-done:
-	case <-dying:
-	}
+	mu.Lock()
+	defer mu.Unlock()
+	return data.String(), err
 }
 
 // xgetwd returns the current directory.
@@ -211,15 +180,6 @@ func isfile(p string) bool {
 	return err == nil && fi.Mode().IsRegular()
 }
 
-// mtime returns the modification time of the file p.
-func mtime(p string) time.Time {
-	fi, err := os.Stat(p)
-	if err != nil {
-		return time.Time{}
-	}
-	return fi.ModTime()
-}
-
 // readfile returns the content of the named file.
 func readfile(file string) string {
 	data, err := ioutil.ReadFile(file)
@@ -274,8 +234,6 @@ func xmkdirall(p string) {
 
 // xremove removes the file p.
 func xremove(p string) {
-
-	_p(1, "[rm file:]", p)
 	if vflag > 2 {
 		errprintf("rm %s\n", p)
 	}
@@ -283,11 +241,7 @@ func xremove(p string) {
 }
 
 // xremoveall removes the file or directory tree rooted at p.
-func xremoveall(p string, nolog ...int) {
-	if nolog == nil {
-		_p(1, "[rm file:]", p)
-	}
-
+func xremoveall(p string) {
 	if vflag > 2 {
 		errprintf("rm -r %s\n", p)
 	}
@@ -343,14 +297,19 @@ func xworkdir() string {
 // fatalf prints an error message to standard error and exits.
 func fatalf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "go tool dist: %s\n", fmt.Sprintf(format, args...))
+	die(0)
+}
 
-	dieOnce.Do(func() { close(dying) })
-
-	// Wait for background goroutines to finish,
-	// so that exit handler that removes the work directory
-	// is not fighting with active writes or open files.
-	bghelpers.Wait()
-
+// die stops the background jobs and exits with status 2.
+// It waits for the running jobs other than the self jobs
+// calling it to finish, so that the exit handler that removes
+// the work directory is not fighting with active writes or open files.
+func die(self int) {
+	if curStage != "" {
+		fmt.Fprintf(os.Stderr, "go tool dist: bootstrap stage %s failed; rerun with -resume to continue from it\n", curStage)
+	}
+	bgcancel.cancel()
+	waitJobs(self)
 	xexit(2)
 }
 
@@ -488,7 +447,9 @@ func (c *count) IsBoolFlag() bool {
 
 func xflagparse(maxargs int) {
 	flag.Var((*count)(&vflag), "v", "verbosity")
+	flag.StringVar(&eventLogName, "eventlog", os.Getenv("GOBUILDEVENTLOG"), "append JSON build events to file")
 	flag.Parse()
+	openEventLog()
 	if maxargs >= 0 && flag.NArg() > maxargs {
 		flag.Usage()
 	}
@@ -514,7 +475,7 @@ func rmworkdir() {
 	if vflag > 1 {
 		errprintf("rm -rf %s\n", workdir)
 	}
-	xremoveall(workdir, 1)
+	xremoveall(workdir)
 }
 
 // compilerEnv returns a map from "goos/goarch" to the
@@ -558,43 +519,79 @@ func compilerEnv(envName, def string) map[string]string {
 	return m
 }
 
+// initKeepGoing is set for dist doctor, which wants to report
+// every problem with the environment, not only the first one.
+// With it set, xinit records problems in initProblems and
+// carries on with whatever values it has; otherwise it calls fatalf.
+var (
+	initKeepGoing bool
+	initProblems  []string
+)
+
+func initProblem(format string, args ...interface{}) {
+	if !initKeepGoing {
+		fatalf(format, args...)
+	}
+	initProblems = append(initProblems, fmt.Sprintf(format, args...))
+}
+
+// settingOrigin records where xinit, and main before it, found
+// the value of each setting: the environment, a probe of the host,
+// or a default. dist env -why reports it.
+var settingOrigin = map[string]string{}
+
+// initEnv returns the value of the environment variable name,
+// recording the environment as the origin of the setting if it is set.
+func initEnv(name string) string {
+	b := os.Getenv(name)
+	if b != "" {
+		settingOrigin[name] = "environment"
+	}
+	return b
+}
+
 // xinit handles initialization of the various global state, like goroot and goarch.
 func xinit() {
-	b := os.Getenv("GOROOT")
+	b := initEnv("GOROOT")
 	if b == "" {
-		fatalf("$GOROOT must be set")
+		initProblem("$GOROOT must be set")
 	}
 	goroot = filepath.Clean(b)
 
-	b = os.Getenv("GOROOT_FINAL")
+	b = initEnv("GOROOT_FINAL")
 	if b == "" {
 		b = goroot
+		settingOrigin["GOROOT_FINAL"] = "default ($GOROOT)"
 	}
 	goroot_final = b
 
-	b = os.Getenv("GOBIN")
+	b = initEnv("GOBIN")
 	if b == "" {
 		b = pathf("%s/bin", goroot)
+		settingOrigin["GOBIN"] = "default ($GOROOT/bin)"
 	}
 	gobin = b
 
-	b = os.Getenv("GOOS")
+	b = initEnv("GOOS")
 	if b == "" {
 		b = gohostos
+		settingOrigin["GOOS"] = "default ($GOHOSTOS)"
 	}
 	goos = b
 	if find(goos, okgoos) < 0 {
-		fatalf("unknown $GOOS %s", goos)
+		initProblem("unknown $GOOS %s", goos)
 	}
 
-	b = os.Getenv("GOARM")
+	b = initEnv("GOARM")
 	if b == "" {
 		b = xgetgoarm()
+		settingOrigin["GOARM"] = "host probe (xgetgoarm)"
 	}
 	goarm = b
 
-	b = os.Getenv("GO386")
+	b = initEnv("GO386")
 	if b == "" {
+		settingOrigin["GO386"] = "host probe (cansse2)"
 		if cansse2() {
 			b = "sse2"
 		} else {
@@ -603,45 +600,49 @@ func xinit() {
 	}
 	go386 = b
 
-	b = os.Getenv("GOMIPS")
+	b = initEnv("GOMIPS")
 	if b == "" {
 		b = "hardfloat"
+		settingOrigin["GOMIPS"] = "default"
 	}
 	gomips = b
 
-	b = os.Getenv("GOMIPS64")
+	b = initEnv("GOMIPS64")
 	if b == "" {
 		b = "hardfloat"
+		settingOrigin["GOMIPS64"] = "default"
 	}
 	gomips64 = b
 
 	if p := pathf("%s/src/all.bash", goroot); !isfile(p) {
-		fatalf("$GOROOT is not set correctly or not exported\n"+
+		initProblem("$GOROOT is not set correctly or not exported\n"+
 			"\tGOROOT=%s\n"+
 			"\t%s does not exist", goroot, p)
 	}
 
-	b = os.Getenv("GOHOSTARCH")
+	b = initEnv("GOHOSTARCH")
 	if b != "" {
 		gohostarch = b
 	}
 	if find(gohostarch, okgoarch) < 0 {
-		fatalf("unknown $GOHOSTARCH %s", gohostarch)
+		initProblem("unknown $GOHOSTARCH %s", gohostarch)
 	}
 
-	b = os.Getenv("GOARCH")
+	b = initEnv("GOARCH")
 	if b == "" {
 		b = gohostarch
+		settingOrigin["GOARCH"] = "default ($GOHOSTARCH)"
 	}
 	goarch = b
 	if find(goarch, okgoarch) < 0 {
-		fatalf("unknown $GOARCH %s", goarch)
+		initProblem("unknown $GOARCH %s", goarch)
 	}
 
-	b = os.Getenv("GO_EXTLINK_ENABLED")
+	settingOrigin["GO_EXTLINK_ENABLED"] = "default (decided by the linker)"
+	b = initEnv("GO_EXTLINK_ENABLED")
 	if b != "" {
 		if b != "0" && b != "1" {
-			fatalf("unknown $GO_EXTLINK_ENABLED %s", b)
+			initProblem("unknown $GO_EXTLINK_ENABLED %s", b)
 		}
 		goextlinkenabled = b
 	}
@@ -655,12 +656,15 @@ func xinit() {
 	defaultcc = compilerEnv("CC", cc)
 	defaultcxx = compilerEnv("CXX", cxx)
 
-	defaultcflags = os.Getenv("CFLAGS")
-	defaultldflags = os.Getenv("LDFLAGS")
+	settingOrigin["CFLAGS"] = "default"
+	settingOrigin["LDFLAGS"] = "default"
+	defaultcflags = initEnv("CFLAGS")
+	defaultldflags = initEnv("LDFLAGS")
 
-	b = os.Getenv("PKG_CONFIG")
+	b = initEnv("PKG_CONFIG")
 	if b == "" {
 		b = "pkg-config"
+		settingOrigin["PKG_CONFIG"] = "default"
 	}
 	defaultpkgconfig = b
 
@@ -734,7 +738,6 @@ func compilerEnvLookup(m map[string]string, goos, goarch string) string {
 
 // copy copies the file src to dst, via memory (so only good for small files).
 func copyfile(dst, src string, flag int) {
-	_pf(1, "[ %s --> %s]\n", src, dst)
 	if vflag > 1 {
 		errprintf("cp %s %s\n", src, dst)
 	}
@@ -748,9 +751,16 @@ func copyfile(dst, src string, flag int) {
 // We also allow the special tag cmd_go_bootstrap.
 // See ../go/bootstrap.go and package go/build.
 func shouldbuild(file, dir string) bool {
+	return excludeReason(file, dir) == ""
+}
+
+// excludeReason implements shouldbuild. It returns the empty string
+// if file should be built, and otherwise a short description of the
+// rule that excluded it, like "GOOS windows" or "+build !linux".
+func excludeReason(file, dir string) string {
 	// Check file name for GOOS or GOARCH.
 	name := filepath.Base(file)
-	excluded := func(list []string, ok string) bool {
+	excluded := func(list []string, ok string) string {
 		for _, x := range list {
 			if x == ok || ok == "android" && x == "linux" {
 				continue
@@ -761,102 +771,57 @@ func shouldbuild(file, dir string) bool {
 			}
 			i += len(x)
 			if i == len(name) || name[i] == '.' || name[i] == '_' {
-				return true
+				return x
 			}
 		}
-		return false
+		return ""
 	}
-	if excluded(okgoos, goos) || excluded(okgoarch, goarch) {
-		return false
+	if x := excluded(okgoos, goos); x != "" {
+		return "GOOS " + x
+	}
+	if x := excluded(okgoarch, goarch); x != "" {
+		return "GOARCH " + x
 	}
 
 	// Omit test files.
-	if strings.Contains(name, "_test") {
-		return false
+	if strings.HasSuffix(name, "_test.go") {
+		return "test file"
 	}
 
-	// Check file contents for // +build lines.
-	for _, p := range strings.Split(readfile(file), "\n") {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		code := p
-		i := strings.Index(code, "//")
-		if i > 0 {
-			code = strings.TrimSpace(code[:i])
-		}
-		if code == "package documentation" {
-			return false
-		}
-		if code == "package main" && dir != "cmd/go" && dir != "cmd/cgo" {
-			return false
-		}
-		if !strings.HasPrefix(p, "//") {
-			break
-		}
-		if !strings.Contains(p, "+build") {
-			continue
-		}
-		fields := strings.Fields(p[2:])
-		if len(fields) < 1 || fields[0] != "+build" {
-			continue
-		}
-		for _, p := range fields[1:] {
-			if matchfield(p) {
-				goto fieldmatch
-			}
-		}
-		return false
-	fieldmatch:
+	// Check the file header for build constraints and the package name.
+	h := readHeader(file)
+	if why := constraintReason(file, h.comments); why != "" {
+		return why
 	}
-
-	return true
+	if h.pkg == "documentation" {
+		return "package documentation"
+	}
+	if h.pkg == "main" && dir != "cmd/go" && dir != "cmd/cgo" {
+		return "package main"
+	}
+	return ""
 }
 
 // dopack copies the package src to dst,
 // appending the files listed in extra.
 // The archive format is the traditional Unix ar format.
 func dopack(dst, src string, extra []string) {
-	bdst := bytes.NewBufferString(readfile(src))
+	members, err := readArchive(src)
+	if err != nil {
+		fatalf("pack: %v", err)
+	}
 	for _, file := range extra {
-		b := readfile(file)
 		// find last path element for archive member name
 		i := strings.LastIndex(file, "/") + 1
 		j := strings.LastIndex(file, `\`) + 1
 		if i < j {
 			i = j
 		}
-		fmt.Fprintf(bdst, "%-16.16s%-12d%-6d%-6d%-8o%-10d`\n", file[i:], 0, 0, 0, 0644, len(b))
-		bdst.WriteString(b)
-		if len(b)&1 != 0 {
-			bdst.WriteByte(0)
-		}
+		members = append(members, &arMember{name: file[i:], mode: 0644, data: []byte(readfile(file))})
 	}
-	writefile(bdst.String(), dst, 0)
-}
-
-// matchfield reports whether the field (x,y,z) matches this build.
-// all the elements in the field must be satisfied.
-func matchfield(f string) bool {
-	for _, tag := range strings.Split(f, ",") {
-		if !matchtag(tag) {
-			return false
-		}
-	}
-	return true
-}
-
-// matchtag reports whether the tag (x or !x) matches this build.
-func matchtag(tag string) bool {
-	if tag == "" {
-		return false
-	}
-	if tag[0] == '!' {
-		if len(tag) == 1 || tag[1] == '!' {
-			return false
-		}
-		return !matchtag(tag[1:])
+	data, err := writeArchive(members)
+	if err != nil {
+		fatalf("pack %s: %v", dst, err)
 	}
-	return tag == "gc" || tag == goos || tag == goarch || tag == "cmd_go_bootstrap" || tag == "go1.1" || (goos == "android" && tag == "linux")
+	writefile(string(data), dst, 0)
 }
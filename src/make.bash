@@ -52,9 +52,10 @@
 # GO_DISTFLAGS: extra flags to provide to "dist bootstrap".
 # (Or just pass them to the make.bash command line.)
 #
-# GOBUILDTIMELOGFILE: If set, make.bash and all.bash write
-# timing information to this file. Useful for profiling where the
-# time goes when these scripts run.
+# GOBUILDEVENTLOG: If set, cmd/dist appends a JSON event to this
+# file for every command it runs and every build phase it enters.
+# Useful for profiling where the time goes when these scripts run;
+# "go tool dist report" summarizes the file.
 #
 # GOROOT_BOOTSTRAP: A working Go tree >= Go 1.4 for bootstrap.
 # If $GOROOT_BOOTSTRAP/bin/go is missing, $(go env GOROOT) is
@@ -72,10 +73,6 @@ if [ ! -f run.bash ]; then
     exit 1
 fi
 
-if [ "$GOBUILDTIMELOGFILE" != "" ]; then
-    echo $(LC_TIME=C date) start make.bash >"$GOBUILDTIMELOGFILE"
-fi
-
 # Test for Windows.
 case "$(uname)" in
 *MINGW* | *WIN32* | *CYGWIN*)